@@ -2,27 +2,90 @@ package account
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/pkg/errors"
 )
 
-func Account() {
-	e, err := casbin.NewEnforcer(
-		"account/account.conf",
-		"account/account.csv",
-	)
+const ObjAccount = "obj:account"
+
+var (
+	ErrInvalidTier = errors.New("invalid account tier")
+	ErrForbidden   = errors.New("forbidden")
+)
+
+// Tier is an account tier such as "company:0" or "division:1". Tiers manage
+// each other as described by account.conf and account.csv.
+type Tier string
+
+func NewTier(kind string, level int) Tier {
+	return Tier(fmt.Sprintf("%s:%d", kind, level))
+}
+
+func (t Tier) Validate() error {
+	i := strings.LastIndex(string(t), ":")
+	if i <= 0 {
+		return errors.Wrap(ErrInvalidTier, string(t))
+	}
+	if _, err := strconv.Atoi(string(t[i+1:])); err != nil {
+		return errors.Wrap(ErrInvalidTier, string(t))
+	}
+	return nil
+}
+
+// Enforcer is satisfied by *casbin.Enforcer and *casbin.SyncedEnforcer.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+type Manager struct {
+	e *casbin.Enforcer
+}
+
+func NewManager(modelPath string, policyPath string) (*Manager, error) {
+	e, err := casbin.NewEnforcer(modelPath, policyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "casbin.NewEnforcer")
+	}
+	return &Manager{e: e}, nil
+}
+
+// CanManage reports whether an account of tier actor may create, edit or
+// delete accounts of tier target.
+func (m *Manager) CanManage(actor Tier, target Tier) (bool, error) {
+	if err := actor.Validate(); err != nil {
+		return false, err
+	}
+	if err := target.Validate(); err != nil {
+		return false, err
+	}
+	ok, err := m.e.Enforce(string(actor), string(target))
 	if err != nil {
-		fmt.Errorf("casbin.NewEnforcer", err)
+		return false, errors.Wrap(err, fmt.Sprintf("Enforce(%s, %s)", actor, target))
 	}
+	return ok, nil
+}
 
-	if err := e.LoadPolicy(); err != nil {
-		fmt.Errorf("LoadPolicy", err)
+// Authorize must be called before act is performed on obj:account. It checks
+// that sub may perform act on accounts in dom through e, and that the actor
+// tier may manage the target tier.
+func (m *Manager) Authorize(e Enforcer, sub string, dom string, act string, actor Tier, target Tier) error {
+	ok, err := e.Enforce(sub, dom, ObjAccount, act)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Enforce(%s, %s, %s, %s)", sub, dom, ObjAccount, act))
+	}
+	if !ok {
+		return errors.Wrap(ErrForbidden, fmt.Sprintf("%s cannot %s %s in %s", sub, act, ObjAccount, dom))
 	}
 
-	fmt.Println()
-	ok, err := e.Enforce("company:-1", "division:0")
+	ok, err = m.CanManage(actor, target)
 	if err != nil {
-		fmt.Errorf("e.Enforce", err)
+		return errors.Wrap(err, "CanManage")
+	}
+	if !ok {
+		return errors.Wrap(ErrForbidden, fmt.Sprintf("%s accounts cannot manage %s accounts", actor, target))
 	}
-	fmt.Printf("%+v", ok)
+	return nil
 }
@@ -0,0 +1,94 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCanManage(t *testing.T) {
+	m, err := NewManager("account.conf", "account.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		actor  Tier
+		target Tier
+		want   bool
+	}{
+		{"company:0", "company:1", true},
+		{"company:0", "company:2", true},
+		{"company:0", "division:1", true},
+		{"company:0", "division:3", true},
+		{"company:0", "guest:-1", true},
+		{"company:0", "company:0", false},
+		{"company:1", "company:2", true},
+		{"company:1", "company:1", false},
+		{"company:1", "company:0", false},
+		{"company:1", "division:1", false},
+		{"division:1", "division:2", true},
+		{"division:1", "division:3", true},
+		{"division:2", "division:1", false},
+		{"division:1", "company:1", false},
+		{"guest:-1", "guest:-1", false},
+	}
+	for _, tt := range tests {
+		got, err := m.CanManage(tt.actor, tt.target)
+		if err != nil {
+			t.Fatalf("CanManage(%s, %s): %v", tt.actor, tt.target, err)
+		}
+		if got != tt.want {
+			t.Errorf("CanManage(%s, %s) = %v, want %v", tt.actor, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestCanManageInvalidTier(t *testing.T) {
+	m, err := NewManager("account.conf", "account.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tiers := range [][2]Tier{
+		{"", "company:1"},
+		{"company", "company:1"},
+		{":0", "company:1"},
+		{"company:x", "company:1"},
+		{"company:0", "division"},
+	} {
+		if _, err := m.CanManage(tiers[0], tiers[1]); errors.Cause(err) != ErrInvalidTier {
+			t.Errorf("CanManage(%q, %q) = %v, want %v", tiers[0], tiers[1], err, ErrInvalidTier)
+		}
+	}
+}
+
+// allowEnforcer allows the listed sub, dom and act, whatever the object.
+type allowEnforcer map[[3]string]bool
+
+func (e allowEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	return e[[3]string{rvals[0].(string), rvals[1].(string), rvals[3].(string)}], nil
+}
+
+func TestAuthorize(t *testing.T) {
+	m, err := NewManager("account.conf", "account.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := allowEnforcer{{"user:ian", "dom:marketing", "act:create"}: true}
+	tests := []struct {
+		act    string
+		actor  Tier
+		target Tier
+		want   error
+	}{
+		{"act:create", "division:1", "division:2", nil},
+		{"act:delete", "division:1", "division:2", ErrForbidden},
+		{"act:create", "division:1", "division:1", ErrForbidden},
+		{"act:create", "division:1", "bogus", ErrInvalidTier},
+	}
+	for _, tt := range tests {
+		err := m.Authorize(e, "user:ian", "dom:marketing", tt.act, tt.actor, tt.target)
+		if errors.Cause(err) != tt.want {
+			t.Errorf("Authorize(%s, %s, %s) = %v, want %v", tt.act, tt.actor, tt.target, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"casbin-playground/account"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUserExists = errors.New("user already exists")
+
+// CreateAccountRequest creates the account Name holding DivisionRole.
+type CreateAccountRequest struct {
+	Name         string       `json:"name"`
+	DivisionRole DivisionRole `json:"divisionRole"`
+}
+
+// UpdateAccountRequest moves an account from one division role to another.
+type UpdateAccountRequest struct {
+	From DivisionRole `json:"from"`
+	To   DivisionRole `json:"to"`
+}
+
+// CreateAccount stores the user req.Name with its first division role. actor
+// needs act:create on obj:account in the role's domain, and its account tier
+// must manage the tier of the new account.
+func CreateAccount(ctx context.Context, e *Enforcer, repo Repository, accounts *account.Manager, actor string, req CreateAccountRequest) (*User, error) {
+	if req.Name == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty user name")
+	}
+	_, err := repo.GetUser(ctx, req.Name)
+	if err == nil {
+		return nil, errors.Wrap(ErrUserExists, req.Name)
+	}
+	if errors.Cause(err) != ErrUserNotFound {
+		return nil, errors.Wrap(err, "GetUser")
+	}
+	if err := authorizeAccount(ctx, e, repo, accounts, actor, "act:create", req.DivisionRole); err != nil {
+		return nil, errors.Wrap(err, "authorizeAccount")
+	}
+
+	if err := AssignDivisionRole(ctx, e, repo, actor, req.Name, req.DivisionRole); err != nil {
+		return nil, errors.Wrap(err, "AssignDivisionRole")
+	}
+	return repo.GetUser(ctx, req.Name)
+}
+
+// UpdateAccount moves userName from req.From to req.To in one policy change.
+// actor must be allowed to update accounts of both tiers.
+func UpdateAccount(ctx context.Context, e *Enforcer, repo Repository, accounts *account.Manager, actor string, userName string, req UpdateAccountRequest) error {
+	if _, err := repo.GetUser(ctx, userName); err != nil {
+		return errors.Wrap(err, "GetUser")
+	}
+	for _, divisionRole := range []DivisionRole{req.From, req.To} {
+		if err := authorizeAccount(ctx, e, repo, accounts, actor, "act:update", divisionRole); err != nil {
+			return errors.Wrap(err, "authorizeAccount")
+		}
+	}
+
+	removed, err := buildRoleAssignment(e, actor, userName, req.From)
+	if err != nil {
+		return errors.Wrap(err, "buildRoleAssignment")
+	}
+	added, err := buildRoleAssignment(e, actor, userName, req.To)
+	if err != nil {
+		return errors.Wrap(err, "buildRoleAssignment")
+	}
+	if !e.HasNamedGroupingPolicy("g", removed) {
+		return errors.Wrap(ErrRoleNotAssigned, strings.Join(removed, ", "))
+	}
+	if e.HasNamedGroupingPolicy("g", added) {
+		return errors.Wrap(ErrRoleAlreadyAssigned, strings.Join(added, ", "))
+	}
+
+	delta := policyDelta{ptype: "g", removed: [][]string{removed}, added: [][]string{added}}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.AddUserDivisionRole(ctx, userName, req.To); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "AddUserDivisionRole"), delta)
+	}
+	if err := repo.RemoveUserDivisionRole(ctx, userName, req.From); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "RemoveUserDivisionRole"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return errors.Wrap(err, "recordPolicyDeltas")
+	}
+	return nil
+}
+
+// DeleteAccount revokes every division role of userName and deletes the
+// user. actor must be allowed to delete accounts of each tier the user holds.
+func DeleteAccount(ctx context.Context, e *Enforcer, repo Repository, accounts *account.Manager, actor string, userName string) error {
+	if _, err := repo.GetUser(ctx, userName); err != nil {
+		return errors.Wrap(err, "GetUser")
+	}
+	divisionRoles, err := ListUserDivisionRoles(ctx, e, repo, userName)
+	if err != nil {
+		return errors.Wrap(err, "ListUserDivisionRoles")
+	}
+
+	delta := policyDelta{ptype: "g"}
+	for _, divisionRole := range divisionRoles {
+		if err := authorizeAccount(ctx, e, repo, accounts, actor, "act:delete", divisionRole); err != nil {
+			return errors.Wrap(err, "authorizeAccount")
+		}
+		rule, err := buildRoleAssignment(e, actor, userName, divisionRole)
+		if err != nil {
+			return errors.Wrap(err, "buildRoleAssignment")
+		}
+		delta.removed = append(delta.removed, rule)
+	}
+
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.DeleteUser(ctx, userName); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "DeleteUser"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return errors.Wrap(err, "recordPolicyDeltas")
+	}
+	return nil
+}
+
+// authorizeAccount must be called before act is performed on an account
+// holding divisionRole. The actor's own grants are checked in the role's
+// domain, except for root, whose grants live in the company domain.
+func authorizeAccount(ctx context.Context, e *Enforcer, repo Repository, accounts *account.Manager, actor string, act string, divisionRole DivisionRole) error {
+	if divisionRole.Division == nil {
+		return errors.Wrap(ErrInvalidRequest, "division is required")
+	}
+	division, err := repo.GetDivision(ctx, divisionRole.Division.Name)
+	if err != nil {
+		return errors.Wrap(err, "GetDivision")
+	}
+	target := accountTier(division.Type, divisionRole.Level)
+
+	actorTier, err := actorAccountTier(ctx, e, repo, actor)
+	if err != nil {
+		return errors.Wrap(err, "actorAccountTier")
+	}
+
+	dom := DomPrefix + string(division.Name)
+	if isRoot(e, actor, string(CompanyDom)) {
+		dom = string(CompanyDom)
+	}
+	return accounts.Authorize(e, actor, dom, act, actorTier, target)
+}
+
+// accountTier returns the account tier of a role at level in a division of
+// divisionType. Company roles keep their level. Division tiers start one
+// below company:0, so level 0 of a division is division:1. Every guest role
+// is guest:-1.
+func accountTier(divisionType DivisionType, level int) account.Tier {
+	switch divisionType {
+	case DivisionTypeCompany:
+		return account.NewTier(string(DivisionTypeCompany), level)
+	case DivisionTypeDivision:
+		return account.NewTier(string(DivisionTypeDivision), level+1)
+	}
+	return account.NewTier(string(DivisionTypeGuest), -1)
+}
+
+// actorAccountTier returns the highest account tier among the roles actor
+// holds: company tiers rank above division tiers, which rank above guest,
+// and smaller levels rank higher within a kind.
+func actorAccountTier(ctx context.Context, e *Enforcer, repo Repository, actor string) (account.Tier, error) {
+	divisionRoles, err := ListUserDivisionRoles(ctx, e, repo, strings.TrimPrefix(actor, UserPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "ListUserDivisionRoles")
+	}
+
+	rank := map[DivisionType]int{DivisionTypeCompany: 0, DivisionTypeDivision: 1, DivisionTypeGuest: 2}
+	var best *DivisionRole
+	for i, divisionRole := range divisionRoles {
+		if _, ok := rank[divisionRole.Division.Type]; !ok {
+			continue
+		}
+		if best == nil ||
+			rank[divisionRole.Division.Type] < rank[best.Division.Type] ||
+			(divisionRole.Division.Type == best.Division.Type && divisionRole.Level < best.Level) {
+			best = &divisionRoles[i]
+		}
+	}
+	if best == nil {
+		return "", errors.Wrap(account.ErrForbidden, fmt.Sprintf("%s holds no account tier", actor))
+	}
+	return accountTier(best.Division.Type, best.Level), nil
+}
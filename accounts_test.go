@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"casbin-playground/account"
+
+	"github.com/pkg/errors"
+)
+
+const accountRules = `
+p, role:admin:1, dom:Company, obj:account, act:create, allow
+p, role:admin:1, dom:Company, obj:account, act:update, allow
+p, role:admin:1, dom:Company, obj:account, act:delete, allow
+p, role:admin:0, dom:marketing, obj:account, act:create, allow
+p, role:admin:0, dom:marketing, obj:account, act:update, allow
+p, role:admin:0, dom:marketing, obj:account, act:delete, allow
+p, role:admin_leader:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:sonnie, role:admin:1, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+g, user:ian2, role:admin_leader:1, dom:marketing
+`
+
+func TestAccountTier(t *testing.T) {
+	tests := []struct {
+		divisionType DivisionType
+		level        int
+		want         account.Tier
+	}{
+		{DivisionTypeCompany, 0, "company:0"},
+		{DivisionTypeCompany, 2, "company:2"},
+		{DivisionTypeDivision, 0, "division:1"},
+		{DivisionTypeDivision, 2, "division:3"},
+		{DivisionTypeGuest, 0, "guest:-1"},
+	}
+	for _, tt := range tests {
+		if got := accountTier(tt.divisionType, tt.level); got != tt.want {
+			t.Errorf("accountTier(%s, %d) = %s, want %s", tt.divisionType, tt.level, got, tt.want)
+		}
+	}
+
+	e := openTestPolicy(t, accountRules+"g, user:sonnie, role:admin_leader:1, dom:marketing\n")
+	repo := openTestRepository(t)
+	for actor, want := range map[string]account.Tier{
+		"user:jason":  "company:0",
+		"user:sonnie": "company:1",
+		"user:ian":    "division:1",
+		"user:ian2":   "division:2",
+	} {
+		got, err := actorAccountTier(context.Background(), e, repo, actor)
+		if err != nil || got != want {
+			t.Errorf("actorAccountTier(%s) = %s, %v, want %s", actor, got, err, want)
+		}
+	}
+	if _, err := actorAccountTier(context.Background(), e, repo, "user:nobody"); errors.Cause(err) != account.ErrForbidden {
+		t.Errorf("actorAccountTier(user:nobody) = %v, want %v", err, account.ErrForbidden)
+	}
+}
+
+func TestAccountHandlers(t *testing.T) {
+	e := openTestPolicy(t, accountRules)
+	repo := openTestRepository(t)
+	accounts, err := account.NewManager("account/account.conf", "account/account.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewActorTokens(map[string]string{
+		"jason-token":  "user:jason",
+		"sonnie-token": "user:sonnie",
+		"ian-token":    "user:ian",
+		"ian2-token":   "user:ian2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(e, repo, accounts, tokens.Authenticate).routes()
+
+	const (
+		editor      = `{"division": {"name": "marketing"}, "name": "editor", "level": 2}`
+		leader      = `{"division": {"name": "marketing"}, "name": "admin_leader", "level": 1}`
+		admin       = `{"division": {"name": "marketing"}, "name": "admin", "level": 0}`
+		companyRole = `{"division": {"name": "Company"}, "name": "admin", "level": 1}`
+	)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{"create without credentials", http.MethodPost, "/accounts", "", `{"name": "zoe", "divisionRole": ` + editor + `}`, http.StatusUnauthorized},
+		{"create without obj:account", http.MethodPost, "/accounts", "ian2-token", `{"name": "zoe", "divisionRole": ` + editor + `}`, http.StatusForbidden},
+		{"create in another domain", http.MethodPost, "/accounts", "ian-token", `{"name": "zoe", "divisionRole": ` + companyRole + `}`, http.StatusForbidden},
+		{"create a lower tier", http.MethodPost, "/accounts", "ian-token", `{"name": "zoe", "divisionRole": ` + editor + `}`, http.StatusCreated},
+		{"create an existing user", http.MethodPost, "/accounts", "ian-token", `{"name": "zoe", "divisionRole": ` + editor + `}`, http.StatusConflict},
+		{"create as root", http.MethodPost, "/accounts", "jason-token", `{"name": "zara", "divisionRole": ` + companyRole + `}`, http.StatusCreated},
+		{"edit to a lower tier", http.MethodPut, "/accounts/zoe", "ian-token", `{"from": ` + editor + `, "to": ` + leader + `}`, http.StatusNoContent},
+		{"edit to their own tier", http.MethodPut, "/accounts/zoe", "ian-token", `{"from": ` + leader + `, "to": ` + admin + `}`, http.StatusForbidden},
+		{"edit an unknown user", http.MethodPut, "/accounts/nobody", "ian-token", `{"from": ` + editor + `, "to": ` + leader + `}`, http.StatusNotFound},
+		{"delete their own tier", http.MethodDelete, "/accounts/zara", "sonnie-token", "", http.StatusForbidden},
+		{"delete without credentials", http.MethodDelete, "/accounts/zoe", "", "", http.StatusUnauthorized},
+		{"delete a lower tier", http.MethodDelete, "/accounts/zoe", "ian-token", "", http.StatusNoContent},
+		{"wrong method", http.MethodGet, "/accounts/zoe", "ian-token", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	if _, err := repo.GetUser(context.Background(), "zoe"); errors.Cause(err) != ErrUserNotFound {
+		t.Errorf("GetUser(zoe) after delete = %v, want %v", err, ErrUserNotFound)
+	}
+	if got := e.GetFilteredNamedGroupingPolicy("g", 0, "user:zoe"); len(got) != 0 {
+		t.Errorf("user:zoe still has %v", got)
+	}
+	if !e.HasNamedGroupingPolicy("g", "user:zara", "role:admin:1", "dom:Company") {
+		t.Error("user:zara lost role:admin:1 in dom:Company")
+	}
+}
+
+func TestCanManageAccountAuthenticatesActor(t *testing.T) {
+	e := openTestPolicy(t, accountRules)
+	accounts, err := account.NewManager("account/account.conf", "account/account.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewActorTokens(map[string]string{"ian-token": "user:ian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(e, openTestRepository(t), accounts, tokens.Authenticate).routes()
+
+	tests := []struct {
+		token string
+		query string
+		want  int
+		body  string
+	}{
+		{"", "target=division:2", http.StatusUnauthorized, ""},
+		{"ian-token", "actor=company:0&target=company:1", http.StatusOK, `{"allowed":false}`},
+		{"ian-token", "target=division:2", http.StatusOK, `{"allowed":true}`},
+		{"ian-token", "target=division:1", http.StatusOK, `{"allowed":false}`},
+		{"ian-token", "target=bogus", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/accounts/can-manage?"+tt.query, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%q: status %d, want %d: %s", tt.query, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.body != "" && strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("%q: body %s, want %s", tt.query, w.Body, tt.body)
+		}
+	}
+}
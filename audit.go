@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+var ErrAuditForbidden = errors.New("audit trail forbidden")
+
+// ReconcileActor is the actor recorded for the g rules scheduled
+// reconciliation rewrites.
+const ReconcileActor = "system:reconcile"
+
+// Actors recorded for the rules that commands rewriting a whole policy
+// change.
+const (
+	ImportActor  = "system:import"
+	LintActor    = "system:lint"
+	MigrateActor = "system:migrate"
+	ReshapeActor = "system:reshape"
+)
+
+type PolicyChangeOp string
+
+const (
+	PolicyChangeAdd    PolicyChangeOp = "add"
+	PolicyChangeRemove PolicyChangeOp = "remove"
+	// PolicyChangeUpdate is a p rule whose effect changed.
+	PolicyChangeUpdate PolicyChangeOp = "update"
+)
+
+// PolicyChange is one entry of the audit trail: a p or g rule that actor
+// added, removed or updated. Before and After are the rule values without
+// the ptype; an add has no Before and a remove no After.
+type PolicyChange struct {
+	ID      uint           `json:"id,omitempty"`
+	Time    time.Time      `json:"time"`
+	Actor   string         `json:"actor"`
+	Op      PolicyChangeOp `json:"op"`
+	Ptype   string         `json:"ptype"`
+	Subject string         `json:"subject"`
+	Dom     string         `json:"dom"`
+	Before  []string       `json:"before,omitempty"`
+	After   []string       `json:"after,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+}
+
+// PolicyChangeFilter selects audit entries. Empty fields match everything;
+// Since is inclusive and Until exclusive.
+type PolicyChangeFilter struct {
+	Actor   string
+	Subject string
+	Dom     string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+type auditReasonKey struct{}
+
+// WithAuditReason returns a context whose policy changes are recorded with
+// reason.
+func WithAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, auditReasonKey{}, reason)
+}
+
+func auditReason(ctx context.Context) string {
+	reason, _ := ctx.Value(auditReasonKey{}).(string)
+	return reason
+}
+
+// recordPolicyDeltas writes applied deltas to the audit trail. When that
+// fails the deltas are reverted, so that no change goes unrecorded.
+func recordPolicyDeltas(ctx context.Context, e *Enforcer, repo Repository, actor string, deltas ...policyDelta) error {
+	changes := policyChanges(e.GetModel(), actor, auditReason(ctx), time.Now(), deltas)
+	if err := repo.RecordPolicyChanges(ctx, changes); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "RecordPolicyChanges"), deltas...)
+	}
+	return nil
+}
+
+// recordRuleRewrite writes to the audit trail how a command that rewrites a
+// whole policy changes its rules from before to after. There is no enforcer
+// to revert, so callers record the rewrite before they write it.
+func recordRuleRewrite(ctx context.Context, repo Repository, m model.Model, actor string, before []CSVRule, after []CSVRule) error {
+	changes := policyChanges(m, actor, auditReason(ctx), time.Now(), ruleDeltas(before, after))
+	if err := repo.RecordPolicyChanges(ctx, changes); err != nil {
+		return errors.Wrap(err, "RecordPolicyChanges")
+	}
+	return nil
+}
+
+// ruleDeltas returns the rules after has more often than before as added
+// and the reverse as removed, in one delta per policy type.
+func ruleDeltas(before []CSVRule, after []CSVRule) []policyDelta {
+	var deltas []policyDelta
+	index := make(map[string]int)
+	delta := func(ptype string) *policyDelta {
+		i, ok := index[ptype]
+		if !ok {
+			i = len(deltas)
+			index[ptype] = i
+			deltas = append(deltas, policyDelta{ptype: ptype})
+		}
+		return &deltas[i]
+	}
+
+	count := make(map[string]int)
+	for _, rule := range before {
+		count[joinRule(rule.Ptype, rule.Rule)]++
+	}
+	for _, rule := range after {
+		if key := joinRule(rule.Ptype, rule.Rule); count[key] > 0 {
+			count[key]--
+			continue
+		}
+		d := delta(rule.Ptype)
+		d.added = append(d.added, rule.Rule)
+	}
+	for _, rule := range before {
+		if key := joinRule(rule.Ptype, rule.Rule); count[key] > 0 {
+			count[key]--
+			d := delta(rule.Ptype)
+			d.removed = append(d.removed, rule.Rule)
+		}
+	}
+	return deltas
+}
+
+// policyChanges turns the rules of deltas into audit entries. A removed and
+// an added p rule that differ only by effect make one update.
+func policyChanges(m model.Model, actor string, reason string, now time.Time, deltas []policyDelta) []PolicyChange {
+	var changes []PolicyChange
+	for _, delta := range deltas {
+		change := func(op PolicyChangeOp, before []string, after []string) PolicyChange {
+			rule := after
+			if rule == nil {
+				rule = before
+			}
+			subject, dom := rule[0], ""
+			if index := domainIndex(delta.ptype); index >= 0 && index < len(rule) {
+				dom = rule[index]
+			}
+			return PolicyChange{Time: now, Actor: actor, Op: op, Ptype: delta.ptype, Subject: subject, Dom: dom, Before: before, After: after, Reason: reason}
+		}
+
+		added := make(map[string][]string)
+		for _, rule := range delta.added {
+			added[ruleKey(m, delta.ptype, rule)] = rule
+		}
+		updated := make(map[string]bool)
+		for _, rule := range delta.removed {
+			key := ruleKey(m, delta.ptype, rule)
+			if after, ok := added[key]; ok && !updated[key] {
+				updated[key] = true
+				changes = append(changes, change(PolicyChangeUpdate, rule, after))
+				continue
+			}
+			changes = append(changes, change(PolicyChangeRemove, rule, nil))
+		}
+		for _, rule := range delta.added {
+			if !updated[ruleKey(m, delta.ptype, rule)] {
+				changes = append(changes, change(PolicyChangeAdd, nil, rule))
+			}
+		}
+	}
+	return changes
+}
+
+// domainIndex is the position of the domain in p and g rules, and -1 in the
+// bundle and action group rules, which have none.
+func domainIndex(ptype string) int {
+	switch ptype {
+	case "p":
+		return 1
+	case "g":
+		return 2
+	}
+	return -1
+}
+
+func joinRule(ptype string, values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return ptype + ", " + strings.Join(values, ", ")
+}
+
+// checkAuditAccess lets root in the company domain read the whole audit
+// trail, and an admin of dom read the entries of dom.
+func checkAuditAccess(e *Enforcer, actor string, dom string) error {
+	if isRoot(e, actor, string(CompanyDom)) {
+		return nil
+	}
+	if dom == "" {
+		return errors.Wrap(ErrAuditForbidden, fmt.Sprintf("%s must filter by a domain it administers", actor))
+	}
+	for _, role := range resolveDomainGrant(e, actor, dom).subjects[1:] {
+		if name, _, err := parseRole(role); err == nil && name == DivisionRoleNameAdmin {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrAuditForbidden, fmt.Sprintf("%s is not an admin of %s", actor, dom))
+}
+
+// encodeRule stores the values of a rule as a JSON array, so that values
+// containing commas survive. A missing rule is stored as "".
+func encodeRule(values []string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal")
+	}
+	return string(b), nil
+}
+
+// decodeRule is the inverse of encodeRule.
+func decodeRule(rule string) ([]string, error) {
+	if rule == "" {
+		return nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(rule), &values); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("json.Unmarshal(%s)", rule))
+	}
+	return values, nil
+}
+
+// parseAuditTime accepts RFC 3339 times and dates such as 2024-05-01.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%q is neither an RFC 3339 time nor a date", value))
+	}
+	return t, nil
+}
+
+func runAuditList(args []string) int {
+	var opts options
+	var filter PolicyChangeFilter
+	fs := flag.NewFlagSet("audit list", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&filter.Actor, "actor", "", "only changes made by this actor, such as user:jason")
+	fs.StringVar(&filter.Subject, "subject", "", "only changes of rules for this subject")
+	fs.StringVar(&filter.Dom, "dom", "", "only changes in this domain")
+	since := fs.String("since", "", "only changes at or after this RFC 3339 time or date")
+	until := fs.String("until", "", "only changes before this RFC 3339 time or date")
+	fs.IntVar(&filter.Limit, "limit", 100, "at most this many changes, 0 for all")
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		return fail("audit list", err)
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		return fail("audit list", err)
+	}
+	repo, err := opts.openRepository(context.Background())
+	if err != nil {
+		return fail("audit list", err)
+	}
+	changes, err := repo.ListPolicyChanges(context.Background(), filter)
+	if err != nil {
+		return fail("audit list", err)
+	}
+	if *format == "json" {
+		return printJSONOrFail("audit list", changes)
+	}
+	writeAuditText(os.Stdout, changes)
+	return 0
+}
+
+func writeAuditText(w io.Writer, changes []PolicyChange) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, change := range changes {
+		rule := joinRule(change.Ptype, change.After)
+		if change.Op == PolicyChangeRemove {
+			rule = joinRule(change.Ptype, change.Before)
+		} else if change.Op == PolicyChangeUpdate {
+			rule = fmt.Sprintf("%s (was %s)", rule, change.Before[len(change.Before)-1])
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", change.Time.Format(time.RFC3339), change.Actor, change.Op, rule, change.Reason)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// changeLines lists changes oldest first as "actor op rule", with the
+// effect an update replaced.
+func changeLines(changes []PolicyChange) []string {
+	lines := []string{}
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		rule := joinRule(change.Ptype, change.After)
+		switch change.Op {
+		case PolicyChangeRemove:
+			rule = joinRule(change.Ptype, change.Before)
+		case PolicyChangeUpdate:
+			rule += " (was " + change.Before[len(change.Before)-1] + ")"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", change.Actor, change.Op, rule))
+	}
+	return lines
+}
+
+func readTestRules(t *testing.T, policy string) []CSVRule {
+	t.Helper()
+	rules, err := readPolicyCSV(strings.NewReader(strings.TrimSpace(policy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestRecordRuleRewrite(t *testing.T) {
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:delete, allow
+g, user:ian, role:editor:1, dom:marketing
+g3, act:create_limited, act:create
+`
+	tests := []struct {
+		name  string
+		after string
+		want  []string
+	}{
+		{
+			name:  "nothing changes",
+			after: before,
+			want:  []string{},
+		},
+		{
+			name: "a duplicate is removed",
+			after: `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:delete, allow
+g, user:ian, role:editor:1, dom:marketing
+g3, act:create_limited, act:create
+`,
+			want: []string{
+				"system:lint remove p, role:editor:1, dom:marketing, obj:news, act:read, allow",
+			},
+		},
+		{
+			name: "rules are added, removed and updated",
+			after: `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:delete, deny
+g, user:sonnie, role:editor:1, dom:marketing
+g3, act:create_limited, act:create
+g3, act:update_limited, act:update
+`,
+			want: []string{
+				"system:lint update p, role:editor:1, dom:marketing, obj:news, act:delete, deny (was allow)",
+				"system:lint remove g, user:ian, role:editor:1, dom:marketing",
+				"system:lint add g, user:sonnie, role:editor:1, dom:marketing",
+				"system:lint add g3, act:update_limited, act:update",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithAuditReason(context.Background(), "test")
+			repo := openTestRepository(t)
+			if err := recordRuleRewrite(ctx, repo, m, LintActor, readTestRules(t, before), readTestRules(t, tt.after)); err != nil {
+				t.Fatal(err)
+			}
+			changes, err := repo.ListPolicyChanges(ctx, PolicyChangeFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := changeLines(changes); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("recorded\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, change := range changes {
+				if change.Reason != "test" {
+					t.Errorf("%s %v has reason %q", change.Op, change.After, change.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateRulesAudit(t *testing.T) {
+	ctx := context.Background()
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := openTestRepository(t)
+	rules := readTestRules(t, `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+g, user:ian, role:editor:1, dom:marketing
+`)
+
+	for _, dryRun := range []bool{true, false, false} {
+		if _, err := MigrateRules(ctx, repo.db, repo, m, rules, dryRun); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes, err := repo.ListPolicyChanges(ctx, PolicyChangeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"system:migrate add p, role:editor:1, dom:marketing, obj:news, act:read, allow",
+		"system:migrate add g, user:ian, role:editor:1, dom:marketing",
+	}
+	if got := changeLines(changes); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("recorded\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPolicyChangesKeepRuleValues(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	want := []PolicyChange{
+		{Actor: "user:jason", Op: PolicyChangeAdd, Ptype: "p", After: []string{"role:editor:1", "dom:a, b", "obj:news", "act:read", "allow"}},
+		{Actor: "user:jason", Op: PolicyChangeRemove, Ptype: "g", Before: []string{"user:ian", "role:editor:1", `dom:"quoted"`}},
+	}
+	if err := repo.RecordPolicyChanges(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := repo.ListPolicyChanges(ctx, PolicyChangeFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, change := range changes {
+		w := want[len(want)-1-i]
+		if fmt.Sprintf("%q %q", change.Before, change.After) != fmt.Sprintf("%q %q", w.Before, w.After) {
+			t.Errorf("change %d: before %q after %q, want %q %q", i, change.Before, change.After, w.Before, w.After)
+		}
+	}
+}
+
+func TestListPolicyChangesAuthenticatesActor(t *testing.T) {
+	e := openTestPolicy(t, delegationRules)
+	tokens, err := NewActorTokens(map[string]string{
+		"jason-token":  "user:jason",
+		"sonnie-token": "user:sonnie",
+		"ian-token":    "user:ian",
+		"ian2-token":   "user:ian2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(e, openTestRepository(t), nil, tokens.Authenticate).routes()
+
+	tests := []struct {
+		token string
+		query string
+		want  int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"", "dom=dom:marketing", http.StatusUnauthorized},
+		{"jason-token", "", http.StatusOK},
+		{"jason-token", "dom=dom:marketing", http.StatusOK},
+		{"ian-token", "dom=dom:marketing", http.StatusOK},
+		{"ian-token", "", http.StatusForbidden},
+		{"ian-token", "dom=dom:Company", http.StatusForbidden},
+		{"sonnie-token", "dom=dom:Company", http.StatusOK},
+		{"ian2-token", "dom=dom:marketing", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/audit?"+tt.query, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %q: status %d, want %d: %s", tt.token, tt.query, w.Code, tt.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator returns the subject that sent r, such as "user:ian". Like a
+// middleware.Extractor it decides who is asking; unlike a plain header it
+// must verify that they are who they claim to be.
+type Authenticator func(r *http.Request) (string, error)
+
+// ActorTokens maps the bearer tokens of callers to the subjects they act as.
+// Only hashes of the tokens are kept.
+type ActorTokens struct {
+	subjects map[[sha256.Size]byte]string
+}
+
+// LoadActorTokens reads a JSON object of tokens and subjects, such as
+// {"0d8f...": "user:jason"}.
+func LoadActorTokens(path string) (*ActorTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return NewActorTokens(tokens)
+}
+
+func NewActorTokens(tokens map[string]string) (*ActorTokens, error) {
+	t := &ActorTokens{subjects: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, subject := range tokens {
+		if token == "" {
+			return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("empty token for %s", subject))
+		}
+		if !hasNamePrefix(subject, []string{UserPrefix}) {
+			return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%q must start with %s", subject, UserPrefix))
+		}
+		t.subjects[sha256.Sum256([]byte(token))] = subject
+	}
+	return t, nil
+}
+
+// Subject returns the subject token belongs to. A nil ActorTokens knows no
+// token.
+func (t *ActorTokens) Subject(token string) (string, error) {
+	if t == nil {
+		return "", errors.Wrap(ErrUnauthenticated, "no actor tokens are configured")
+	}
+	if token == "" {
+		return "", errors.Wrap(ErrUnauthenticated, "no token")
+	}
+	subject, ok := t.subjects[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", errors.Wrap(ErrUnauthenticated, "unknown token")
+	}
+	return subject, nil
+}
+
+// Authenticate is an Authenticator reading "Authorization: Bearer <token>".
+func (t *ActorTokens) Authenticate(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", errors.Wrap(ErrUnauthenticated, "missing Authorization: Bearer header")
+	}
+	return t.Subject(strings.TrimSpace(token))
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"casbin-playground/db"
+	"casbin-playground/registry"
+	"casbin-playground/v1"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const usage = `usage: casbin-playground <command> [flags]
+
+commands:
+  serve                  serve the HTTP API (the default)
+  enforce                decide one request
+  explain                explain one decision
+  users list             list users and their division roles
+  roles list             list divisions and their roles
+  matrix user|role       print a permission matrix
+  assign, revoke         give or take a division role
+  policy import|export   copy rules between a CSV file and the policy source
+  policy compact|expand  rewrite action sets as action groups, or back
+  diff                   compare effective permissions of two policies
+  audit list             show who changed which rules
+  migrate                copy a CSV policy into a database, skipping stored rules
+  lint                   find mistakes in a policy, removing some with -fix
+
+Run a command with -h for its flags. -policy, -db and -model default to
+$CASBIN_POLICY, $PLAYGROUND_DB and $CASBIN_MODEL.
+`
+
+// runCommand dispatches args to a command and returns the exit code. Flags
+// without a command start the server, so "go run . -addr :8080" still works.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" {
+		return runServe(args)
+	}
+
+	name, args := args[0], args[1:]
+	switch name {
+	case "serve":
+		return runServe(args)
+	case "diff":
+		return runDiff(args)
+	case "migrate":
+		return runMigrate(args)
+	case "lint":
+		return runLint(args)
+	case "enforce":
+		return runEnforce(args)
+	case "explain":
+		return runExplain(args)
+	case "users":
+		return runSubcommand(name, args, map[string]func([]string) int{"list": runUsersList})
+	case "roles":
+		return runSubcommand(name, args, map[string]func([]string) int{"list": runRolesList})
+	case "matrix":
+		return runSubcommand(name, args, map[string]func([]string) int{"user": runUserMatrix, "role": runRoleMatrix})
+	case "assign":
+		return runAssignment(name, args, AssignDivisionRole)
+	case "revoke":
+		return runAssignment(name, args, RevokeDivisionRole)
+	case "audit":
+		return runSubcommand(name, args, map[string]func([]string) int{"list": runAuditList})
+	case "policy":
+		return runSubcommand(name, args, map[string]func([]string) int{
+			"import": runPolicyImport,
+			"export": runPolicyExport,
+			"compact": func(args []string) int {
+				return runPolicyReshape("policy compact", args, CompactRules, ExpandRules)
+			},
+			"expand": func(args []string) int {
+				return runPolicyReshape("policy expand", args, ExpandRules, CompactRules)
+			},
+		})
+	case "help", "-h", "-help":
+		fmt.Print(usage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+	return 2
+}
+
+func runSubcommand(name string, args []string, commands map[string]func([]string) int) int {
+	if len(args) > 0 {
+		if run, ok := commands[args[0]]; ok {
+			return run(args[1:])
+		}
+	}
+	names := make([]string, 0, len(commands))
+	for sub := range commands {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: %s <%s> [flags]\n", name, strings.Join(names, "|"))
+	return 2
+}
+
+// options are the flags every command shares. Their defaults come from the
+// environment, so one deployment can point all commands at its database.
+type options struct {
+	modelPath    string
+	policy       string
+	dbPath       string
+	registryPath string
+	registryDB   bool
+	tokensPath   string
+
+	gdb *gorm.DB
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.modelPath, "model", envOr("CASBIN_MODEL", DefaultModelPath), "casbin model")
+	fs.StringVar(&o.policy, "policy", envOr("CASBIN_POLICY", DefaultPolicyPath), "policy source: CSV path, sqlite:<path> or mysql:<dsn>")
+	fs.StringVar(&o.dbPath, "db", envOr("PLAYGROUND_DB", "playground.db"), "SQLite database of users, divisions and division roles")
+	fs.StringVar(&o.registryPath, "registry", "", "JSON file of the objects and actions to build policies from")
+	fs.BoolVar(&o.registryDB, "registry-db", false, "read objects and actions from the database, seeding it on first use")
+	fs.BoolVar(&levelInheritance, "level-inheritance", false, "let roles inherit the grants of higher-numbered roles in the same division")
+	fs.StringVar(&o.tokensPath, "tokens", envOr("PLAYGROUND_TOKENS", ""), "JSON file of the bearer tokens of actors and the subjects they act as")
+}
+
+// openEnforcer loads the vocabulary and then the policy.
+func (o *options) openEnforcer() (*Enforcer, error) {
+	if err := o.loadVocabulary(); err != nil {
+		return nil, err
+	}
+	e, err := openPolicySource(o.modelPath, o.policy)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("openPolicySource(%s)", o.policy))
+	}
+	return e, nil
+}
+
+// loadVocabulary replaces the default vocabulary, here and in v1, with the
+// one -registry or -registry-db points at.
+func (o *options) loadVocabulary() error {
+	var err error
+	switch {
+	case o.registryPath != "":
+		if vocabulary, err = registry.Load(o.registryPath); err != nil {
+			return errors.Wrap(err, "registry.Load")
+		}
+	case o.registryDB:
+		gdb, err := o.openDB()
+		if err != nil {
+			return err
+		}
+		if err := registry.SeedDB(gdb, vocabulary); err != nil {
+			return errors.Wrap(err, "registry.SeedDB")
+		}
+		if vocabulary, err = registry.LoadDB(gdb); err != nil {
+			return errors.Wrap(err, "registry.LoadDB")
+		}
+	}
+	v1.SetRegistry(vocabulary)
+	return nil
+}
+
+// openActorTokens loads -tokens. Without it, no token authenticates anyone.
+func (o *options) openActorTokens() (*ActorTokens, error) {
+	if o.tokensPath == "" {
+		return nil, nil
+	}
+	tokens, err := LoadActorTokens(o.tokensPath)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("LoadActorTokens(%s)", o.tokensPath))
+	}
+	return tokens, nil
+}
+
+// openRepository opens the users and divisions, seeding an empty database.
+func (o *options) openRepository(ctx context.Context) (Repository, error) {
+	gdb, err := o.openDB()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := newGormRepository(gdb)
+	if err != nil {
+		return nil, errors.Wrap(err, "newGormRepository")
+	}
+	if err := seedRepository(ctx, repo); err != nil {
+		return nil, errors.Wrap(err, "seedRepository")
+	}
+	return repo, nil
+}
+
+func (o *options) openDB() (*gorm.DB, error) {
+	if o.gdb != nil {
+		return o.gdb, nil
+	}
+	gdb, err := db.OpenSQLite(o.dbPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.OpenSQLite")
+	}
+	if err := db.Migrate(gdb); err != nil {
+		return nil, errors.Wrap(err, "db.Migrate")
+	}
+	o.gdb = gdb
+	return gdb, nil
+}
+
+func runEnforce(args []string) int {
+	var opts options
+	var decisionOpts decisionLogOptions
+	var req ExplainRequest
+	fs := flag.NewFlagSet("enforce", flag.ExitOnError)
+	opts.register(fs)
+	decisionOpts.register(fs)
+	registerRequestFlags(fs, &req)
+	fs.Parse(args)
+
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail("enforce", err)
+	}
+	closeDecisionLog, err := decisionOpts.open(&opts)
+	if err != nil {
+		return fail("enforce", err)
+	}
+	defer closeDecisionLog()
+	if req.Sub == "" {
+		return fail("enforce", errors.Wrap(ErrInvalidRequest, "-sub is required"))
+	}
+	if err := validateEnforceRequest(req.EnforceRequest); err != nil {
+		return fail("enforce", err)
+	}
+	// Decided by the enforcer itself, as the server middleware decides.
+	d, err := enforce(e, req.Sub, req.Dom, req.Obj, req.Act)
+	if err != nil {
+		return fail("enforce", err)
+	}
+	if !d.allowed {
+		fmt.Println(EffectDeny)
+		return 1
+	}
+	fmt.Println(EffectAllow)
+	return 0
+}
+
+func runExplain(args []string) int {
+	var opts options
+	var decisionOpts decisionLogOptions
+	var req ExplainRequest
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	opts.register(fs)
+	decisionOpts.register(fs)
+	registerRequestFlags(fs, &req)
+	fs.Parse(args)
+
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail("explain", err)
+	}
+	closeDecisionLog, err := decisionOpts.open(&opts)
+	if err != nil {
+		return fail("explain", err)
+	}
+	defer closeDecisionLog()
+	explanation, err := Explain(context.Background(), e, req)
+	if err != nil {
+		return fail("explain", err)
+	}
+	if err := printJSON(explanation); err != nil {
+		return fail("explain", err)
+	}
+	return 0
+}
+
+func registerRequestFlags(fs *flag.FlagSet, req *ExplainRequest) {
+	fs.StringVar(&req.Sub, "sub", "", "subject, such as user:ian")
+	fs.StringVar(&req.Dom, "dom", "", "domain, such as dom:marketing")
+	fs.StringVar(&req.Obj, "obj", "", "object, such as obj:news")
+	fs.StringVar(&req.Act, "act", "", "action, such as act:read")
+}
+
+func runUsersList(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("users list", flag.ExitOnError)
+	opts.register(fs)
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	repo, err := opts.openRepository(context.Background())
+	if err != nil {
+		return fail("users list", err)
+	}
+	users, err := repo.ListUsers(context.Background())
+	if err != nil {
+		return fail("users list", err)
+	}
+	if *format == "json" {
+		return printJSONOrFail("users list", users)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, user := range users {
+		var roles []string
+		for _, divisionRole := range user.DivisionRoles {
+			roles = append(roles, fmt.Sprintf(RolePrefixFormat+" %s%s", divisionRole.Name, divisionRole.Level, DomPrefix, divisionRole.Division.Name))
+		}
+		fmt.Fprintf(w, "%s%s\t%s\n", UserPrefix, user.Name, strings.Join(roles, ", "))
+	}
+	w.Flush()
+	return 0
+}
+
+func runRolesList(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("roles list", flag.ExitOnError)
+	opts.register(fs)
+	division := fs.String("division", "", "only list the roles of this division")
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	repo, err := opts.openRepository(context.Background())
+	if err != nil {
+		return fail("roles list", err)
+	}
+	divisions, err := repo.ListDivisions(context.Background())
+	if err != nil {
+		return fail("roles list", err)
+	}
+	if *division != "" {
+		var filtered []Division
+		for _, d := range divisions {
+			if string(d.Name) == *division {
+				filtered = append(filtered, d)
+			}
+		}
+		divisions = filtered
+	}
+	if *format == "json" {
+		return printJSONOrFail("roles list", divisions)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, d := range divisions {
+		for _, divisionRole := range d.DivisionRoles {
+			fmt.Fprintf(w, "%s%s\t%s\t"+RolePrefixFormat+"\n", DomPrefix, d.Name, d.Type, divisionRole.Name, divisionRole.Level)
+		}
+	}
+	w.Flush()
+	return 0
+}
+
+func runUserMatrix(args []string) int {
+	var opts options
+	var decisionOpts decisionLogOptions
+	fs := flag.NewFlagSet("matrix user", flag.ExitOnError)
+	opts.register(fs)
+	decisionOpts.register(fs)
+	name := fs.String("name", "", "user name, without the user: prefix")
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail("matrix user", err)
+	}
+	closeDecisionLog, err := decisionOpts.open(&opts)
+	if err != nil {
+		return fail("matrix user", err)
+	}
+	defer closeDecisionLog()
+	repo, err := opts.openRepository(context.Background())
+	if err != nil {
+		return fail("matrix user", err)
+	}
+	user, err := GetUserPermission(context.Background(), e, repo, *name)
+	if err != nil {
+		return fail("matrix user", err)
+	}
+	if *format == "json" {
+		return printJSONOrFail("matrix user", user.Permissions)
+	}
+	writeMatrixText(user.Permissions)
+	return 0
+}
+
+func runRoleMatrix(args []string) int {
+	var opts options
+	var decisionOpts decisionLogOptions
+	var divisionRole DivisionRole
+	fs := flag.NewFlagSet("matrix role", flag.ExitOnError)
+	opts.register(fs)
+	decisionOpts.register(fs)
+	division := registerDivisionRoleFlags(fs, &divisionRole)
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail("matrix role", err)
+	}
+	closeDecisionLog, err := decisionOpts.open(&opts)
+	if err != nil {
+		return fail("matrix role", err)
+	}
+	defer closeDecisionLog()
+	role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
+	permissions, err := getRolePermissionsFromPolicy(context.Background(), e, role, DomPrefix+*division)
+	if err != nil {
+		return fail("matrix role", err)
+	}
+	if *format == "json" {
+		return printJSONOrFail("matrix role", permissions)
+	}
+	writeMatrixText(permissions)
+	return 0
+}
+
+// writeMatrixText prints one row per object or bundle and one column per
+// action: "x" allowed, "i" allowed through level inheritance, "-" denied.
+func writeMatrixText(permissions []Permission) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	fmt.Fprint(w, "\t", strings.Join(vocabulary.TrimmedActions(), "\t"), "\n")
+	for _, permission := range permissions {
+		fmt.Fprint(w, permission.Name)
+		for _, action := range permission.Actions {
+			mark := "-"
+			switch {
+			case action.Inherited:
+				mark = "i"
+			case action.Status:
+				mark = "x"
+			}
+			fmt.Fprint(w, "\t", mark)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// registerDivisionRoleFlags binds -role and -level to divisionRole and
+// returns the -division value.
+func registerDivisionRoleFlags(fs *flag.FlagSet, divisionRole *DivisionRole) *string {
+	division := fs.String("division", "", "division name, such as marketing")
+	fs.Func("role", "division role name, such as admin", func(value string) error {
+		divisionRole.Name = DivisionRoleName(value)
+		return nil
+	})
+	fs.IntVar(&divisionRole.Level, "level", 0, "division role level")
+	return division
+}
+
+func runAssignment(name string, args []string, apply func(context.Context, *Enforcer, Repository, string, string, DivisionRole) error) int {
+	var opts options
+	var divisionRole DivisionRole
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts.register(fs)
+	token := fs.String("token", envOr("PLAYGROUND_TOKEN", ""), "bearer token of the acting subject, one of -tokens")
+	userName := fs.String("user", "", "user name, without the user: prefix")
+	division := registerDivisionRoleFlags(fs, &divisionRole)
+	reason := fs.String("reason", "", "reason recorded in the audit trail")
+	fs.Parse(args)
+
+	tokens, err := opts.openActorTokens()
+	if err != nil {
+		return fail(name, err)
+	}
+	actor, err := tokens.Subject(*token)
+	if err != nil {
+		return fail(name, err)
+	}
+	ctx := WithAuditReason(context.Background(), *reason)
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail(name, err)
+	}
+	repo, err := opts.openRepository(ctx)
+	if err != nil {
+		return fail(name, err)
+	}
+	divisionRole.Division = &Division{Name: DivisionName(*division)}
+	if err := apply(ctx, e, repo, actor, *userName, divisionRole); err != nil {
+		return fail(name, err)
+	}
+	return 0
+}
+
+// runPolicyImport replaces every rule of the policy source with those of a
+// CSV file, recording the rules it adds and removes as ImportActor.
+func runPolicyImport(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("policy import", flag.ExitOnError)
+	opts.register(fs)
+	in := fs.String("in", "", "CSV file to read")
+	reason := fs.String("reason", "", "reason recorded in the audit trail")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fail("policy import", errors.Wrap(ErrInvalidRequest, "-in is required"))
+	}
+	ctx := WithAuditReason(context.Background(), *reason)
+	src, err := openPolicySource(opts.modelPath, *in)
+	if err != nil {
+		return fail("policy import", err)
+	}
+	adapter, err := policyAdapter(opts.policy)
+	if err != nil {
+		return fail("policy import", err)
+	}
+	before, err := readPolicySource(ctx, opts.policy)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return fail("policy import", errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", opts.policy)))
+	}
+	after, err := readPolicySource(ctx, *in)
+	if err != nil {
+		return fail("policy import", errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", *in)))
+	}
+	repo, err := opts.openRepository(ctx)
+	if err != nil {
+		return fail("policy import", err)
+	}
+	if err := adapter.SavePolicy(src.GetModel()); err != nil {
+		return fail("policy import", errors.Wrap(err, "SavePolicy"))
+	}
+	// Like recordPolicyDeltas, put the previous rules back when the import
+	// cannot be recorded, so that no change goes unrecorded.
+	if err := recordRuleRewrite(ctx, repo, src.GetModel(), ImportActor, before, after); err != nil {
+		previous, restoreErr := enforcerFromRules(opts.modelPath, src.GetModel(), before)
+		if restoreErr == nil {
+			restoreErr = adapter.SavePolicy(previous.GetModel())
+		}
+		if restoreErr != nil {
+			err = errors.Wrap(err, fmt.Sprintf("restoring %s: %v", opts.policy, restoreErr))
+		}
+		return fail("policy import", err)
+	}
+	return 0
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printJSONOrFail(name string, v interface{}) int {
+	if err := printJSON(v); err != nil {
+		return fail(name, err)
+	}
+	return 0
+}
+
+// fail logs err for command name and returns the exit code of failures.
+func fail(name string, err error) int {
+	log.Printf("%s: %v", name, err)
+	return 2
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
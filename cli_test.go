@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cliRules = `
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+p, role:editor:2, dom:marketing, obj:news, act:update, deny
+p, user:zoe, dom:marketing, obj:news, act:create, allow
+g, user:zoe, role:editor:2, dom:marketing
+`
+
+// writeCLIFiles copies the model and writes rules to a policy file in a
+// temporary directory, returning the flags that point a command at them.
+func writeCLIFiles(t *testing.T, rules string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	model, err := os.ReadFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelPath := filepath.Join(dir, "model.conf")
+	if err := os.WriteFile(modelPath, model, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policyPath := filepath.Join(dir, "policy.csv")
+	if err := os.WriteFile(policyPath, []byte(strings.TrimSpace(rules+groupRules)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"-model", modelPath, "-policy", policyPath, "-db", filepath.Join(dir, "playground.db")}
+}
+
+// runTestCommand runs args through runCommand and returns the exit code and
+// what the command printed to stdout. Logs and stderr are discarded.
+func runTestCommand(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	log.SetOutput(io.Discard)
+	code := runCommand(args)
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+	log.SetOutput(os.Stderr)
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(out)
+}
+
+func TestRunCommandDispatch(t *testing.T) {
+	files := writeCLIFiles(t, cliRules)
+	tests := []struct {
+		args []string
+		want int
+		out  string
+	}{
+		{[]string{"bogus"}, 2, ""},
+		{[]string{"matrix"}, 2, ""},
+		{[]string{"matrix", "bogus"}, 2, ""},
+		{[]string{"policy", "bogus"}, 2, ""},
+		{[]string{"help"}, 0, "usage: casbin-playground"},
+		{append([]string{"users", "list"}, files...), 0, "user:ian2     role:admin_leader:1 dom:marketing"},
+		{append([]string{"roles", "list", "-division", "marketing"}, files...), 0, "role:admin_leader:1"},
+		{append([]string{"explain", "-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:update"}, files...), 0, `"explicit_deny"`},
+	}
+	for _, tt := range tests {
+		code, out := runTestCommand(t, tt.args...)
+		if code != tt.want {
+			t.Errorf("%s: exit code %d, want %d", strings.Join(tt.args[:1], " "), code, tt.want)
+		}
+		if !strings.Contains(out, tt.out) {
+			t.Errorf("%s: output %q does not contain %q", strings.Join(tt.args[:1], " "), out, tt.out)
+		}
+	}
+}
+
+func TestRunEnforceExitCodes(t *testing.T) {
+	files := writeCLIFiles(t, cliRules)
+	tests := []struct {
+		name string
+		args []string
+		want int
+		out  string
+	}{
+		{"allowed", []string{"-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:read"}, 0, "allow\n"},
+		{"the user's own rule", []string{"-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:create"}, 0, "allow\n"},
+		{"denied", []string{"-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:update"}, 1, "deny\n"},
+		{"no rule", []string{"-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:delete"}, 1, "deny\n"},
+		{"no subject", []string{"-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:read"}, 2, ""},
+		{"a malformed request", []string{"-sub", "user:zoe", "-dom", "marketing", "-obj", "obj:news", "-act", "act:read"}, 2, ""},
+	}
+	for _, tt := range tests {
+		code, out := runTestCommand(t, append(append([]string{"enforce"}, tt.args...), files...)...)
+		if code != tt.want || out != tt.out {
+			t.Errorf("%s: exit code %d, output %q, want %d, %q", tt.name, code, out, tt.want, tt.out)
+		}
+	}
+
+	code, _ := runTestCommand(t, "enforce", "-sub", "user:zoe", "-dom", "dom:marketing", "-obj", "obj:news", "-act", "act:read",
+		"-model", DefaultModelPath, "-policy", filepath.Join(t.TempDir(), "missing.csv"))
+	if code != 2 {
+		t.Errorf("missing policy: exit code %d, want 2", code)
+	}
+}
+
+func TestRunMatrixText(t *testing.T) {
+	files := writeCLIFiles(t, cliRules)
+	tests := []struct {
+		name string
+		args []string
+		rows map[string]string
+	}{
+		{
+			name: "role",
+			args: []string{"matrix", "role", "-division", "marketing", "-role", "editor", "-level", "2"},
+			rows: map[string]string{
+				"":        "read create update delete create_limited update_limited delete_limited",
+				"news":    "news x - - - - - -",
+				"account": "account - - - - - - -",
+			},
+		},
+		{
+			name: "user",
+			args: []string{"matrix", "user", "-name", "ian2"},
+			rows: map[string]string{
+				"news": "news - - - - - - -",
+			},
+		},
+	}
+	for _, tt := range tests {
+		code, out := runTestCommand(t, append(tt.args, files...)...)
+		if code != 0 {
+			t.Fatalf("%s: exit code %d", tt.name, code)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if header := strings.Join(strings.Fields(lines[0]), " "); tt.rows[""] != "" && header != tt.rows[""] {
+			t.Errorf("%s: header %q, want %q", tt.name, header, tt.rows[""])
+		}
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if want, ok := tt.rows[fields[0]]; ok && strings.Join(fields, " ") != want {
+				t.Errorf("%s: row %q, want %q", tt.name, strings.Join(fields, " "), want)
+			}
+		}
+		if len(lines)-1 != len(vocabulary.Objects()) {
+			t.Errorf("%s: %d rows, want one per object", tt.name, len(lines)-1)
+		}
+	}
+}
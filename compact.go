@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+// actionGroups maps the groups defined by ActionGroupType rules to the
+// vocabulary actions each covers, in vocabulary order. Like
+// v1.actionExpansionMapping, act:all covers every action and act:create
+// covers act:create and act:create_limited.
+func actionGroups(e *Enforcer) map[string][]string {
+	groups := make(map[string][]string)
+	for _, g := range e.GetNamedGroupingPolicy(ActionGroupType) {
+		if _, ok := groups[g[1]]; !ok {
+			groups[g[1]] = impliedActions(e, g[1])
+		}
+	}
+	return groups
+}
+
+// CompactRules rewrites the p rules that grant a subject several actions on
+// one object into as few rules as the action groups allow, such as one
+// act:create rule for act:create and act:create_limited or one act:all rule
+// for every action. A group replaces actions only when it covers nothing
+// else. The compacted rules take the place of the first rule they replace, in
+// vocabulary order: a group is placed where the first action it covers would
+// be. Rules are left as they are when a rule of the other effect overlaps
+// them, because their order then decides.
+func CompactRules(e *Enforcer, rules []CSVRule) []CSVRule {
+	groups := actionGroups(e)
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(groups[names[i]]) != len(groups[names[j]]) {
+			return len(groups[names[i]]) > len(groups[names[j]])
+		}
+		return names[i] < names[j]
+	})
+
+	// Rules of one subject, domain, object and effect form a cell set.
+	key := func(rule CSVRule) string {
+		return strings.Join([]string{rule.Rule[0], rule.Rule[1], rule.Rule[2], rule.Rule[4]}, ", ")
+	}
+	sets := make(map[string][]CSVRule)
+	for _, rule := range rules {
+		if rule.Ptype == "p" && len(rule.Rule) == 5 {
+			sets[key(rule)] = append(sets[key(rule)], rule)
+		}
+	}
+
+	var compacted []CSVRule
+	done := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Ptype != "p" || len(rule.Rule) != 5 {
+			compacted = append(compacted, rule)
+			continue
+		}
+		k := key(rule)
+		if done[k] {
+			continue
+		}
+		done[k] = true
+		set := sets[k]
+		if len(set) == 1 || !knownActions(e, set) || overlapsOtherEffect(e, rules, set) {
+			compacted = append(compacted, set...)
+			continue
+		}
+
+		actions := make(map[string]bool)
+		for _, r := range set {
+			for _, act := range impliedActions(e, r.Rule[3]) {
+				actions[act] = true
+			}
+		}
+		// Larger groups are chosen first, so that act:all wins over the
+		// groups it contains.
+		covered := make(map[string]bool)
+		chosen := make(map[string]string)
+		for _, name := range names {
+			if len(groups[name]) < 2 || !coversAll(actions, groups[name]) || coversAll(covered, groups[name]) {
+				continue
+			}
+			chosen[groups[name][0]] = name
+			for _, act := range groups[name] {
+				covered[act] = true
+			}
+		}
+		for _, act := range vocabulary.Actions() {
+			if name, ok := chosen[act]; ok {
+				compacted = append(compacted, setAction(rule, name))
+			}
+			if actions[act] && !covered[act] {
+				compacted = append(compacted, setAction(rule, act))
+			}
+		}
+	}
+	return compacted
+}
+
+// ExpandRules replaces every p rule on an action group with one rule per
+// vocabulary action the group covers, in vocabulary order and in place.
+func ExpandRules(e *Enforcer, rules []CSVRule) []CSVRule {
+	groups := actionGroups(e)
+	var expanded []CSVRule
+	for _, rule := range rules {
+		actions, ok := groups[ruleAction(rule)]
+		if rule.Ptype != "p" || !ok {
+			expanded = append(expanded, rule)
+			continue
+		}
+		for _, act := range actions {
+			expanded = append(expanded, setAction(rule, act))
+		}
+	}
+	return expanded
+}
+
+func ruleAction(rule CSVRule) string {
+	if len(rule.Rule) < 4 {
+		return ""
+	}
+	return rule.Rule[3]
+}
+
+func setAction(rule CSVRule, act string) CSVRule {
+	values := append([]string(nil), rule.Rule...)
+	values[3] = act
+	return CSVRule{Ptype: rule.Ptype, Rule: values}
+}
+
+// knownActions reports whether every rule of set grants at least one
+// vocabulary action, so that compaction loses none of them.
+func knownActions(e *Enforcer, set []CSVRule) bool {
+	for _, rule := range set {
+		if len(impliedActions(e, rule.Rule[3])) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func coversAll(set map[string]bool, values []string) bool {
+	for _, value := range values {
+		if !set[value] {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapsOtherEffect reports whether a p rule of the same subject and
+// domain but the other effect covers a permission that set covers.
+func overlapsOtherEffect(e *Enforcer, rules []CSVRule, set []CSVRule) bool {
+	first := set[0].Rule
+	for _, other := range rules {
+		if other.Ptype != "p" || len(other.Rule) != 5 || other.Rule[0] != first[0] ||
+			other.Rule[1] != first[1] || other.Rule[4] == first[4] {
+			continue
+		}
+		for _, r := range set {
+			for _, obj := range impliedObjects(e, r.Rule[2]) {
+				for _, act := range impliedActions(e, r.Rule[3]) {
+					if ruleCovers(e, other.Rule, obj, act) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// DecisionMismatch is a request two policies decide differently.
+type DecisionMismatch struct {
+	Sub    string `json:"sub"`
+	Dom    string `json:"dom"`
+	Obj    string `json:"obj"`
+	Act    string `json:"act"`
+	Before bool   `json:"before"`
+	After  bool   `json:"after"`
+}
+
+// CompareDecisions asks both enforcers every request that combines a subject
+// and a domain of either policy with an object and an action either policy
+// can decide: those of the vocabulary, of the p rules, and the bundles and
+// action groups. Action groups that are not vocabulary actions, such as
+// act:all, are left out: requests name actions, and a rule on act:all stands
+// for the actions it covers. It returns the number of requests and those
+// decided differently.
+func CompareDecisions(before *Enforcer, after *Enforcer) (int, []DecisionMismatch, error) {
+	subjects := subjectDomains(before)
+	for sub, doms := range subjectDomains(after) {
+		if _, ok := subjects[sub]; !ok {
+			subjects[sub] = doms
+		}
+	}
+	domains := make(map[string]bool)
+	for _, doms := range subjects {
+		for dom := range doms {
+			domains[dom] = true
+		}
+	}
+	objects := requestNames(vocabulary.Objects(), ObjectGroupType, 2, before, after)
+	actions := requestNames(vocabulary.Actions(), ActionGroupType, 3, before, after)
+	for _, e := range []*Enforcer{before, after} {
+		for name := range actionGroups(e) {
+			if !vocabulary.HasAction(name) {
+				delete(actions, name)
+			}
+		}
+	}
+
+	count := 0
+	var mismatches []DecisionMismatch
+	for _, sub := range sortedKeys(subjects) {
+		for _, dom := range sortedKeys(domains) {
+			for _, obj := range sortedKeys(objects) {
+				for _, act := range sortedKeys(actions) {
+					was, err := before.Enforce(sub, dom, obj, act)
+					if err != nil {
+						return 0, nil, errors.Wrap(err, "Enforce")
+					}
+					is, err := after.Enforce(sub, dom, obj, act)
+					if err != nil {
+						return 0, nil, errors.Wrap(err, "Enforce")
+					}
+					count++
+					if was != is {
+						mismatches = append(mismatches, DecisionMismatch{Sub: sub, Dom: dom, Obj: obj, Act: act, Before: was, After: is})
+					}
+				}
+			}
+		}
+	}
+	return count, mismatches, nil
+}
+
+// requestNames returns names with the values of column field of every p rule
+// and the names linked by grouping rules of ptype, in any of enforcers.
+func requestNames(names []string, ptype string, field int, enforcers ...*Enforcer) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range names {
+		set[name] = true
+	}
+	for _, e := range enforcers {
+		for _, p := range e.GetPolicy() {
+			set[p[field]] = true
+		}
+		for _, g := range e.GetNamedGroupingPolicy(ptype) {
+			set[g[0]] = true
+			set[g[1]] = true
+		}
+	}
+	return set
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// enforcerFromRules loads rules the way a CSV policy source is loaded, by
+// writing them to a temporary file.
+func enforcerFromRules(modelPath string, m model.Model, rules []CSVRule) (*Enforcer, error) {
+	f, err := os.CreateTemp("", "policy-*.csv")
+	if err != nil {
+		return nil, errors.Wrap(err, "os.CreateTemp")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := writePolicyCSV(f, m, rules); err != nil {
+		return nil, errors.Wrap(err, "writePolicyCSV")
+	}
+	return openPolicySource(modelPath, f.Name())
+}
+
+// runPolicyReshape implements "policy compact" and "policy expand". Before
+// writing anything it checks that the result and its inverse decide every
+// request as the source does. A result written with -out is recorded in the
+// audit trail as ReshapeActor.
+func runPolicyReshape(name string, args []string, reshape func(*Enforcer, []CSVRule) []CSVRule, inverse func(*Enforcer, []CSVRule) []CSVRule) int {
+	var opts options
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts.register(fs)
+	out := fs.String("out", "", "CSV file to write instead of standard output")
+	reason := fs.String("reason", "", "reason recorded in the audit trail for -out")
+	fs.Parse(args)
+
+	m, err := model.NewModelFromFile(opts.modelPath)
+	if err != nil {
+		return fail(name, errors.Wrap(err, "model.NewModelFromFile"))
+	}
+	e, err := opts.openEnforcer()
+	if err != nil {
+		return fail(name, err)
+	}
+	rules, err := readPolicySource(context.Background(), opts.policy)
+	if err != nil {
+		return fail(name, errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", opts.policy)))
+	}
+	for i := range rules {
+		rules[i].Line = 0
+	}
+
+	reshaped := reshape(e, rules)
+	for _, form := range []struct {
+		label string
+		rules []CSVRule
+	}{
+		{"result", reshaped},
+		{"round trip", inverse(e, reshaped)},
+	} {
+		other, err := enforcerFromRules(opts.modelPath, m, form.rules)
+		if err != nil {
+			return fail(name, err)
+		}
+		count, mismatches, err := CompareDecisions(e, other)
+		if err != nil {
+			return fail(name, err)
+		}
+		for _, mismatch := range mismatches {
+			fmt.Fprintf(os.Stderr, "%s: %s %s %s %s: %t, was %t\n", form.label,
+				mismatch.Sub, mismatch.Dom, mismatch.Obj, mismatch.Act, mismatch.After, mismatch.Before)
+		}
+		if len(mismatches) > 0 {
+			return fail(name, errors.Errorf("%s decides %d of %d requests differently", form.label, len(mismatches), count))
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d requests decided the same\n", form.label, count)
+	}
+
+	var f *os.File
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		ctx := WithAuditReason(context.Background(), *reason)
+		repo, err := opts.openRepository(ctx)
+		if err != nil {
+			return fail(name, err)
+		}
+		if err := recordRuleRewrite(ctx, repo, m, ReshapeActor, rules, reshaped); err != nil {
+			return fail(name, err)
+		}
+		if f, err = os.Create(*out); err != nil {
+			return fail(name, errors.Wrap(err, "os.Create"))
+		}
+		w = f
+	}
+	err = writePolicyCSV(w, m, reshaped)
+	if f != nil {
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "Close")
+		}
+	}
+	if err != nil {
+		return fail(name, err)
+	}
+	fmt.Fprintf(os.Stderr, "%d rules, %d before\n", len(reshaped), len(rules))
+	return 0
+}
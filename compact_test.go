@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// ruleLines lists the rules of ptype as CSV lines.
+func ruleLines(rules []CSVRule, ptype string) []string {
+	lines := []string{}
+	for _, rule := range rules {
+		if rule.Ptype == ptype {
+			lines = append(lines, rule.Ptype+", "+strings.Join(rule.Rule, ", "))
+		}
+	}
+	return lines
+}
+
+func TestCompactPolicy(t *testing.T) {
+	ctx := context.Background()
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := openPolicySource(DefaultModelPath, DefaultPolicyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := readPolicySource(ctx, DefaultPolicyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range rules {
+		rules[i].Line = 0
+	}
+
+	compacted := CompactRules(e, rules)
+	want := []string{
+		"p, role:admin:1, dom:Company, obj:account, act:all, allow",
+		"p, role:admin_member:2, dom:Company, obj:account, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:account, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:location, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:organiser, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:period, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:exhibition, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:news_tag, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:news, act:all, allow",
+		"p, role:admin:0, dom:marketing, obj:request_form, act:all, allow",
+		"p, role:organiser:0, dom:Guest, obj:exhibition, act:all_limited, allow",
+		"p, role:organiser:0, dom:Guest, obj:news, act:all_limited, allow",
+		"p, user:sonnie, dom:Company, obj:news, act:all, allow",
+	}
+	if got := ruleLines(compacted, "p"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("compacted p rules =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, ptype := range []string{"g", ObjectGroupType, ActionGroupType} {
+		if got, want := ruleLines(compacted, ptype), ruleLines(rules, ptype); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("compacted %s rules = %v, want %v", ptype, got, want)
+		}
+	}
+
+	// Expansion writes actions in vocabulary order, which is not the order
+	// of policy_my.csv.
+	expanded := ExpandRules(e, compacted)
+	got, want := ruleLines(expanded, "p"), ruleLines(rules, "p")
+	sort.Strings(got)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expanded p rules =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for label, reshaped := range map[string][]CSVRule{"compacted": compacted, "expanded": expanded} {
+		other, err := enforcerFromRules(DefaultModelPath, m, reshaped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, mismatches, err := CompareDecisions(e, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 || len(mismatches) > 0 {
+			t.Errorf("%s: %d of %d requests decided differently: %+v", label, len(mismatches), count, mismatches)
+		}
+	}
+}
+
+func TestCompactRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{
+			name: "groups keep vocabulary order",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:update_limited, allow
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+p, role:editor:1, dom:marketing, obj:news, act:create_limited, allow
+p, role:editor:1, dom:marketing, obj:news, act:update, allow
+`,
+			want: []string{
+				"p, role:editor:1, dom:marketing, obj:news, act:read, allow",
+				"p, role:editor:1, dom:marketing, obj:news, act:create, allow",
+				"p, role:editor:1, dom:marketing, obj:news, act:update, allow",
+			},
+		},
+		{
+			name: "a group that covers more is not used",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+`,
+			want: []string{
+				"p, role:editor:1, dom:marketing, obj:news, act:read, allow",
+				"p, role:editor:1, dom:marketing, obj:news, act:create, allow",
+			},
+		},
+		{
+			name: "granted groups are merged",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:1, dom:marketing, obj:news, act:update, allow
+p, role:editor:1, dom:marketing, obj:news, act:delete, allow
+`,
+			want: []string{
+				"p, role:editor:1, dom:marketing, obj:news, act:all, allow",
+			},
+		},
+		{
+			name: "rules that overlap a deny are kept",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+p, role:editor:1, dom:marketing, obj:news, act:create_limited, allow
+p, role:editor:1, dom:marketing, obj:news, act:create_limited, deny
+`,
+			want: []string{
+				"p, role:editor:1, dom:marketing, obj:news, act:create, allow",
+				"p, role:editor:1, dom:marketing, obj:news, act:create_limited, allow",
+				"p, role:editor:1, dom:marketing, obj:news, act:create_limited, deny",
+			},
+		},
+		{
+			name: "objects and effects are kept apart",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:delete, allow
+p, role:editor:1, dom:marketing, obj:period, act:delete_limited, allow
+p, role:editor:1, dom:marketing, obj:news, act:delete_limited, allow
+p, role:editor:1, dom:marketing, obj:period, act:delete, allow
+`,
+			want: []string{
+				"p, role:editor:1, dom:marketing, obj:news, act:delete, allow",
+				"p, role:editor:1, dom:marketing, obj:period, act:delete, allow",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := openTestPolicy(t, tt.rules)
+			rules, err := readPolicyCSV(strings.NewReader(strings.TrimSpace(tt.rules)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ruleLines(CompactRules(e, rules), "p"); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("CompactRules =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/pkg/errors"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// OpenMySQL opens a MySQL database such as the one in docker-compose.yml,
+// "user:password@tcp(127.0.0.1:3306)/database?charset=utf8&parseTime=True&loc=Local".
+func OpenMySQL(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN: dsn,
+	}))
+	if err != nil {
+		return nil, errors.Wrap(err, "gorm.Open")
+	}
+	return db, nil
+}
+
+// NewAdapter returns a gorm adapter that keeps policy rules in the
+// casbin_rule table, shaped by CasbinRule.
+func NewAdapter(db *gorm.DB) (*gormadapter.Adapter, error) {
+	adapter, err := gormadapter.NewAdapterByDBWithCustomTable(db, &CasbinRule{})
+	if err != nil {
+		return nil, errors.Wrap(err, "gormadapter.NewAdapterByDBWithCustomTable")
+	}
+	if db.Dialector.Name() != "mysql" {
+		return adapter, nil
+	}
+	if err := db.Exec("ALTER TABLE casbin_rule CHANGE `created_at` `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP").Error; err != nil {
+		return nil, errors.Wrap(err, "db.Exec")
+	}
+	if err := db.Exec("ALTER TABLE casbin_rule CHANGE `updated_at` `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP").Error; err != nil {
+		return nil, errors.Wrap(err, "db.Exec")
+	}
+	return adapter, nil
+}
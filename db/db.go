@@ -6,10 +6,7 @@ import (
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/constant"
-	gormadapter "github.com/casbin/gorm-adapter/v3"
 	"github.com/pkg/errors"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 )
 
 type CasbinRule struct {
@@ -26,28 +23,20 @@ type CasbinRule struct {
 	UpdatedAt time.Time
 }
 
-func newEnforcerByDB() (e *casbin.Enforcer, err error) {
+func newEnforcerByDB() (*casbin.Enforcer, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", "user", "password", "127.0.0.1", "3306", "database")
-	db, err := gorm.Open(mysql.New(mysql.Config{
-		DSN: dsn,
-	}))
+	db, err := OpenMySQL(dsn)
 	if err != nil {
-		fmt.Errorf("gorm.Open", err)
+		return nil, errors.Wrap(err, "OpenMySQL")
 	}
-	adapter, err := gormadapter.NewAdapterByDBWithCustomTable(db, &CasbinRule{})
+	adapter, err := NewAdapter(db)
 	if err != nil {
-		fmt.Errorf("gormadapter.NewAdapterByDBWithCustomTable", err)
-	}
-	if err := db.Exec("ALTER TABLE casbin_rule CHANGE `created_at` `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP").Error; err != nil {
-		fmt.Errorf("db.Exec", err)
-	}
-	if err := db.Exec("ALTER TABLE casbin_rule CHANGE `updated_at` `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP").Error; err != nil {
-		fmt.Errorf("db.Exec", err)
+		return nil, errors.Wrap(err, "NewAdapter")
 	}
 
-	e, err = casbin.NewEnforcer("model_my.conf")
+	e, err := casbin.NewEnforcer("model_my.conf")
 	if err != nil {
-		fmt.Errorf("casbin.NewEnforcer", err)
+		return nil, errors.Wrap(err, "casbin.NewEnforcer")
 	}
 	e.SetFieldIndex("p", constant.SubjectIndex, 0)
 	e.SetFieldIndex("p", constant.DomainIndex, 1)
@@ -56,8 +45,7 @@ func newEnforcerByDB() (e *casbin.Enforcer, err error) {
 	e.SetAdapter(adapter)
 
 	if err := e.LoadPolicy(); err != nil {
-		err = errors.Wrap(err, "LoadPolicy")
+		return nil, errors.Wrap(err, "LoadPolicy")
 	}
-	return
-
+	return e, nil
 }
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type Division struct {
+	ID   uint   `gorm:"primaryKey;autoIncrement"`
+	Name string `gorm:"type:varchar(100);uniqueIndex"`
+	Type string `gorm:"type:varchar(15)"`
+
+	DivisionRoles []DivisionRole
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type DivisionRole struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	DivisionID uint   `gorm:"uniqueIndex:unique_division_role"`
+	Name       string `gorm:"type:varchar(100);uniqueIndex:unique_division_role"`
+	Level      int    `gorm:"uniqueIndex:unique_division_role"`
+
+	Division Division
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type User struct {
+	ID   uint   `gorm:"primaryKey;autoIncrement"`
+	Name string `gorm:"type:varchar(100);uniqueIndex"`
+
+	UserDivisionRoles []UserDivisionRole
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UserDivisionRole is a user's membership of a division role.
+type UserDivisionRole struct {
+	ID             uint `gorm:"primaryKey;autoIncrement"`
+	UserID         uint `gorm:"uniqueIndex:unique_user_division_role"`
+	DivisionRoleID uint `gorm:"uniqueIndex:unique_user_division_role"`
+
+	User         User
+	DivisionRole DivisionRole
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// VocabularyEntry is one object or action of the registry, named without its
+// prefix and listed in Position order within its Kind.
+type VocabularyEntry struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement"`
+	Kind        string `gorm:"type:varchar(15);uniqueIndex:unique_vocabulary_entry"`
+	Name        string `gorm:"type:varchar(100);uniqueIndex:unique_vocabulary_entry"`
+	Description string `gorm:"type:varchar(255)"`
+	Position    int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// PolicyAudit is one add, remove or update of a p or g rule. Before and After
+// hold the rule values as a JSON array such as ["role:admin:0", "dom:sales"];
+// an add has no Before and a remove no After.
+type PolicyAudit struct {
+	ID      uint   `gorm:"primaryKey;autoIncrement"`
+	Actor   string `gorm:"type:varchar(100);index"`
+	Op      string `gorm:"type:varchar(15)"`
+	Ptype   string `gorm:"type:varchar(15)"`
+	Subject string `gorm:"type:varchar(100);index"`
+	Dom     string `gorm:"type:varchar(100);index"`
+	Before  string `gorm:"type:varchar(700)"`
+	After   string `gorm:"type:varchar(700)"`
+	Reason  string `gorm:"type:varchar(255)"`
+
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// DecisionLog is one enforcement decision, written by decisionlog.DBSink.
+type DecisionLog struct {
+	ID            uint      `gorm:"primaryKey;autoIncrement"`
+	Time          time.Time `gorm:"index"`
+	Sub           string    `gorm:"type:varchar(100);index"`
+	Dom           string    `gorm:"type:varchar(100)"`
+	Obj           string    `gorm:"type:varchar(100)"`
+	Act           string    `gorm:"type:varchar(100)"`
+	Allowed       bool
+	MatchedRule   string `gorm:"type:varchar(700)"`
+	RootClause    bool
+	PolicyVersion string `gorm:"type:varchar(32)"`
+	LatencyNs     int64
+}
+
+// Migrate creates or updates the user, division, division role, membership,
+// vocabulary and policy audit tables.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Division{}, &DivisionRole{}, &User{}, &UserDivisionRole{}, &VocabularyEntry{}, &PolicyAudit{}); err != nil {
+		return errors.Wrap(err, "AutoMigrate")
+	}
+	return nil
+}
+
+// OpenSQLite opens the SQLite database at path for local development and
+// tests.
+func OpenSQLite(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.New(log.New(os.Stderr, "", log.LstdFlags), logger.Config{
+			SlowThreshold:             200 * time.Millisecond,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+		}),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "gorm.Open")
+	}
+	return db, nil
+}
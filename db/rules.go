@@ -0,0 +1,31 @@
+package db
+
+// TableName is the table gormadapter keeps rules in, so that plain gorm
+// queries on CasbinRule read the same rows.
+func (CasbinRule) TableName() string {
+	return "casbin_rule"
+}
+
+// NewCasbinRule returns the casbin_rule row of a rule of ptype, such as "p"
+// with sub, dom, obj, act and eft. Values past V5 are dropped.
+func NewCasbinRule(ptype string, values []string) CasbinRule {
+	rule := CasbinRule{Ptype: ptype}
+	fields := []*string{&rule.V0, &rule.V1, &rule.V2, &rule.V3, &rule.V4, &rule.V5}
+	for i, value := range values {
+		if i == len(fields) {
+			break
+		}
+		*fields[i] = value
+	}
+	return rule
+}
+
+// Values returns V0 to V5 without the empty values at the end, the way the
+// rule was written.
+func (r CasbinRule) Values() []string {
+	values := []string{r.V0, r.V1, r.V2, r.V3, r.V4, r.V5}
+	for len(values) > 0 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+	return values
+}
@@ -0,0 +1,284 @@
+package decisionlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/pkg/errors"
+)
+
+// Decision is one enforcement result and what it was based on.
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Sub     string    `json:"sub"`
+	Dom     string    `json:"dom"`
+	Obj     string    `json:"obj"`
+	Act     string    `json:"act"`
+	Allowed bool      `json:"allowed"`
+	// MatchedRule is the p rule that decided, if any.
+	MatchedRule []string `json:"matchedRule,omitempty"`
+	// RootClause is set when the root role was allowed without a p rule.
+	RootClause bool `json:"rootClause,omitempty"`
+	// PolicyVersion is the Fingerprint of the policy that decided.
+	PolicyVersion string        `json:"policyVersion"`
+	Latency       time.Duration `json:"latencyNs"`
+}
+
+// Sink stores decisions. Write is called from one goroutine at a time.
+type Sink interface {
+	Write(decisions []Decision) error
+	Close() error
+}
+
+// Redactor edits a decision before it is stored, for example to hide who
+// the subject is.
+type Redactor func(d *Decision)
+
+type Config struct {
+	Sink Sink
+	// SampleRate is the share of decisions kept, from 0 to 1. Zero keeps
+	// every decision.
+	SampleRate float64
+	// KeepDenied keeps every denied decision regardless of SampleRate.
+	KeepDenied bool
+	Redactors  []Redactor
+	// BufferSize is the number of decisions waiting to be written before new
+	// ones are dropped. It defaults to 4096.
+	BufferSize int
+}
+
+// Logger writes decisions to its Sink from a background goroutine. Log never
+// blocks: when the buffer is full the decision is dropped and counted.
+type Logger struct {
+	sink       Sink
+	sampleRate float64
+	keepDenied bool
+	redactors  []Redactor
+
+	queue   chan Decision
+	done    chan struct{}
+	dropped uint64
+
+	// mu guards closed, so that Log never sends on the closed queue.
+	mu     sync.RWMutex
+	closed bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func New(cfg Config) (*Logger, error) {
+	if cfg.Sink == nil {
+		return nil, errors.New("sink is required")
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, errors.Errorf("sample rate %v is not between 0 and 1", cfg.SampleRate)
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 4096
+	}
+
+	l := &Logger{
+		sink:       cfg.Sink,
+		sampleRate: cfg.SampleRate,
+		keepDenied: cfg.KeepDenied,
+		redactors:  cfg.Redactors,
+		queue:      make(chan Decision, cfg.BufferSize),
+		done:       make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log queues d unless sampling skips it. It is safe to call on a nil or
+// closed Logger, which drop d.
+func (l *Logger) Log(d Decision) {
+	if l == nil {
+		return
+	}
+	if l.sampleRate > 0 && l.sampleRate < 1 && !(l.keepDenied && !d.Allowed) && rand.Float64() >= l.sampleRate {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- d:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// Dropped returns how many decisions were lost to a full buffer.
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close writes the queued decisions and closes the sink. Decisions logged
+// afterwards are dropped.
+func (l *Logger) Close() error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.closed = true
+		close(l.queue)
+		l.mu.Unlock()
+
+		<-l.done
+		l.closeErr = l.sink.Close()
+		if dropped := l.Dropped(); dropped > 0 {
+			log.Printf("decisionlog: dropped %d decisions", dropped)
+		}
+	})
+	return l.closeErr
+}
+
+const maxBatch = 256
+
+func (l *Logger) run() {
+	defer close(l.done)
+	batch := make([]Decision, 0, maxBatch)
+	for d := range l.queue {
+		batch = append(batch[:0], l.redact(d))
+		// Take whatever else is already waiting, up to maxBatch.
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case d, ok := <-l.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, l.redact(d))
+			default:
+				break fill
+			}
+		}
+		if err := l.sink.Write(batch); err != nil {
+			log.Printf("decisionlog: write %d decisions: %v", len(batch), err)
+		}
+	}
+}
+
+func (l *Logger) redact(d Decision) Decision {
+	d.MatchedRule = append([]string(nil), d.MatchedRule...)
+	for _, redactor := range l.redactors {
+		redactor(&d)
+	}
+	return d
+}
+
+// HashSubjects replaces subjects starting with prefix, such as "user:", by
+// prefix and a salted hash, so that one person's decisions can still be
+// told apart without naming them. The matched rule is hashed the same way.
+func HashSubjects(prefix string, salt string) Redactor {
+	hash := func(value string) string {
+		if !strings.HasPrefix(value, prefix) {
+			return value
+		}
+		sum := sha256.Sum256([]byte(salt + value))
+		return prefix + hex.EncodeToString(sum[:8])
+	}
+	return func(d *Decision) {
+		d.Sub = hash(d.Sub)
+		for i, value := range d.MatchedRule {
+			d.MatchedRule[i] = hash(value)
+		}
+	}
+}
+
+// Fingerprint identifies the rules of e and their order, which decides
+// between rules of the same subject. It changes whenever a rule does.
+func Fingerprint(e *casbin.Enforcer) string {
+	h := sha256.New()
+	m := e.GetModel()
+	for _, sec := range []string{"p", "g"} {
+		ptypes := make([]string, 0, len(m[sec]))
+		for ptype := range m[sec] {
+			ptypes = append(ptypes, ptype)
+		}
+		sort.Strings(ptypes)
+		for _, ptype := range ptypes {
+			for _, rule := range m[sec][ptype].Policy {
+				h.Write([]byte(ptype + ", " + strings.Join(rule, ", ") + "\n"))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// RootFunc reports whether the root clause of the model applies to sub in
+// dom. That clause allows without a p rule of sub, and casbin then reports
+// whichever allow rule it tried first.
+type RootFunc func(e *casbin.Enforcer, sub string, dom string) bool
+
+// Decide decides a "sub, dom, obj, act" request with e and works out what
+// decided it: the matched p rule, or the root clause when root reports it
+// applies to an allowed request. PolicyVersion is left to the caller.
+func Decide(e *casbin.Enforcer, root RootFunc, sub string, dom string, obj string, act string) (Decision, error) {
+	start := time.Now()
+	allowed, rule, err := e.EnforceEx(sub, dom, obj, act)
+	if err != nil {
+		return Decision{}, err
+	}
+	d := Decision{Time: start, Sub: sub, Dom: dom, Obj: obj, Act: act, Allowed: allowed}
+	if allowed && root != nil && root(e, sub, dom) {
+		d.RootClause = true
+	} else if len(rule) > 0 {
+		d.MatchedRule = rule
+	}
+	d.Latency = time.Since(start)
+	return d, nil
+}
+
+// Enforcer logs every decision of a casbin enforcer, so that it can stand in
+// for one in middleware.Config. Call PolicyChanged after editing the policy.
+type Enforcer struct {
+	e       *casbin.Enforcer
+	logger  *Logger
+	root    RootFunc
+	version atomic.Value
+}
+
+// NewEnforcer wraps e. root tells the root clause of e's model apart, and
+// may be nil when the model has none.
+func NewEnforcer(e *casbin.Enforcer, logger *Logger, root RootFunc) *Enforcer {
+	enforcer := &Enforcer{e: e, logger: logger, root: root}
+	enforcer.PolicyChanged()
+	return enforcer
+}
+
+// PolicyChanged updates the policy version recorded with decisions.
+func (e *Enforcer) PolicyChanged() {
+	e.version.Store(Fingerprint(e.e))
+}
+
+// Enforce decides a "sub, dom, obj, act" request like casbin.Enforcer does.
+func (e *Enforcer) Enforce(rvals ...interface{}) (bool, error) {
+	var req [4]string
+	if len(rvals) != len(req) {
+		return false, errors.Errorf("%d request values, want sub, dom, obj and act", len(rvals))
+	}
+	for i, rval := range rvals {
+		value, ok := rval.(string)
+		if !ok {
+			return false, errors.Errorf("request value %d is a %T, not a string", i, rval)
+		}
+		req[i] = value
+	}
+	d, err := Decide(e.e, e.root, req[0], req[1], req[2], req[3])
+	if err != nil {
+		return false, err
+	}
+	d.PolicyVersion = e.version.Load().(string)
+	e.logger.Log(d)
+	return d.Allowed, nil
+}
@@ -0,0 +1,226 @@
+package decisionlog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"casbin-playground/middleware"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// memorySink keeps every decision written to it.
+type memorySink struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+func (s *memorySink) Write(decisions []Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, decisions...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		sampleRate  float64
+		keepDenied  bool
+		allowedFrom int
+		allowedTo   int
+		denied      int
+	}{
+		{name: "zero keeps everything", sampleRate: 0, allowedFrom: 2000, allowedTo: 2000, denied: 200},
+		{name: "one keeps everything", sampleRate: 1, allowedFrom: 2000, allowedTo: 2000, denied: 200},
+		{name: "a quarter", sampleRate: 0.25, allowedFrom: 350, allowedTo: 650, denied: 0},
+		{name: "a quarter keeping denials", sampleRate: 0.25, keepDenied: true, allowedFrom: 350, allowedTo: 650, denied: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			logger, err := New(Config{Sink: sink, SampleRate: tt.sampleRate, KeepDenied: tt.keepDenied})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2000; i++ {
+				logger.Log(Decision{Allowed: true})
+			}
+			for i := 0; i < 200; i++ {
+				logger.Log(Decision{Allowed: false})
+			}
+			if err := logger.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			allowed, denied := 0, 0
+			for _, d := range sink.decisions {
+				if d.Allowed {
+					allowed++
+				} else {
+					denied++
+				}
+			}
+			if allowed < tt.allowedFrom || allowed > tt.allowedTo {
+				t.Errorf("kept %d allowed decisions, want %d to %d", allowed, tt.allowedFrom, tt.allowedTo)
+			}
+			if tt.denied > 0 && denied != tt.denied || tt.denied == 0 && denied > 100 {
+				t.Errorf("kept %d denied decisions, want %d", denied, tt.denied)
+			}
+		})
+	}
+}
+
+func TestLogAfterClose(t *testing.T) {
+	sink := &memorySink{}
+	logger, err := New(Config{Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(Decision{Sub: "user:ian"})
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logger.Log(Decision{Sub: "user:sonnie"})
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.decisions) != 1 || sink.decisions[0].Sub != "user:ian" {
+		t.Errorf("sink has %+v, want the decision logged before Close", sink.decisions)
+	}
+}
+
+func TestHashSubjects(t *testing.T) {
+	rule := []string{"user:ian", "dom:marketing", "obj:news", "act:read", "allow"}
+	logged := []Decision{
+		{Sub: "user:ian", MatchedRule: rule},
+		{Sub: "user:ian"},
+		{Sub: "user:sonnie"},
+		{Sub: "role:admin:0", MatchedRule: []string{"role:admin:0", "dom:marketing", "obj:news", "act:read", "allow"}},
+	}
+	redact := func(salt string) []Decision {
+		sink := &memorySink{}
+		logger, err := New(Config{Sink: sink, Redactors: []Redactor{HashSubjects("user:", salt)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range logged {
+			logger.Log(d)
+		}
+		if err := logger.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return sink.decisions
+	}
+
+	redacted := redact("s3cret")
+	ian := redacted[0].Sub
+	if !strings.HasPrefix(ian, "user:") || strings.Contains(ian, "ian") {
+		t.Errorf("user:ian is logged as %q", ian)
+	}
+	if redacted[0].MatchedRule[0] != ian || redacted[0].MatchedRule[1] != "dom:marketing" {
+		t.Errorf("matched rule is logged as %v, want %s first", redacted[0].MatchedRule, ian)
+	}
+	if redacted[1].Sub != ian {
+		t.Errorf("user:ian is logged as %q and %q", ian, redacted[1].Sub)
+	}
+	if redacted[2].Sub == ian {
+		t.Errorf("user:sonnie and user:ian are both logged as %q", ian)
+	}
+	if redacted[3].Sub != "role:admin:0" || redacted[3].MatchedRule[0] != "role:admin:0" {
+		t.Errorf("role subjects are logged as %+v", redacted[3])
+	}
+	if rule[0] != "user:ian" {
+		t.Errorf("the caller's matched rule was changed to %v", rule)
+	}
+	if other := redact("other"); other[0].Sub == ian {
+		t.Errorf("user:ian is logged as %q with either salt", ian)
+	}
+}
+
+// isRoot is the root clause of model_my.conf.
+func isRoot(e *casbin.Enforcer, sub string, dom string) bool {
+	ok, err := e.GetRoleManager().HasLink(sub, "role:root:0", dom)
+	return dom == "dom:Company" && err == nil && ok
+}
+
+func TestEnforcerWithMiddleware(t *testing.T) {
+	e, err := casbin.NewEnforcer("../model_my.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddPolicy("user:ian", "dom:Company", "obj:news", "act:read", "allow"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddGroupingPolicy("user:jason", "role:root:0", "dom:Company"); err != nil {
+		t.Fatal(err)
+	}
+	sink := &memorySink{}
+	logger, err := New(Config{Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enforcer := NewEnforcer(e, logger, isRoot)
+	m, err := middleware.New(middleware.Config{
+		Enforcer:  enforcer,
+		Extractor: middleware.HeaderExtractor("X-Sub", "X-Dom"),
+		Routes:    middleware.CRUD("/news", "obj:news"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	requests := []struct {
+		method string
+		sub    string
+		status int
+	}{
+		{http.MethodGet, "user:ian", http.StatusOK},
+		{http.MethodDelete, "user:ian", http.StatusForbidden},
+		{http.MethodDelete, "user:jason", http.StatusOK},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, "/news", nil)
+		r.Header.Set("X-Sub", req.sub)
+		r.Header.Set("X-Dom", "dom:Company")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != req.status {
+			t.Errorf("%s /news as %s = %d, want %d", req.method, req.sub, w.Code, req.status)
+		}
+	}
+	version := Fingerprint(e)
+	if _, err := e.AddPolicy("user:sonnie", "dom:Company", "obj:news", "act:read", "allow"); err != nil {
+		t.Fatal(err)
+	}
+	enforcer.PolicyChanged()
+	if ok, err := enforcer.Enforce("user:sonnie", "dom:Company", "obj:news", "act:read"); err != nil || !ok {
+		t.Fatalf("Enforce = %t, %v, want true", ok, err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range sink.decisions {
+		got = append(got, fmt.Sprintf("%s %s %t %v %t %t", d.Sub, d.Act, d.Allowed, d.MatchedRule, d.RootClause, d.PolicyVersion == version))
+	}
+	want := []string{
+		"user:ian act:read true [user:ian dom:Company obj:news act:read allow] false true",
+		"user:ian act:delete false [] false true",
+		"user:jason act:delete true [] true true",
+		"user:sonnie act:read true [user:sonnie dom:Company obj:news act:read allow] false false",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("logged\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,142 @@
+package decisionlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"casbin-playground/db"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// FileSink appends decisions to a JSONL file, one JSON object per line. When
+// the file would grow past MaxBytes it is renamed to path.1, path.1 to
+// path.2 and so on, keeping MaxBackups old files.
+type FileSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending. A maxBytes of 0 never rotates.
+func NewFileSink(path string, maxBytes int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "Stat")
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(decisions []Decision) error {
+	w := bufio.NewWriter(s.f)
+	for _, d := range decisions {
+		line, err := json.Marshal(d)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		line = append(line, '\n')
+		if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+			if err := w.Flush(); err != nil {
+				return errors.Wrap(err, "Flush")
+			}
+			if err := s.rotate(); err != nil {
+				return errors.Wrap(err, "rotate")
+			}
+			w.Reset(s.f)
+		}
+		if _, err := w.Write(line); err != nil {
+			return errors.Wrap(err, "Write")
+		}
+		s.size += int64(len(line))
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil {
+			return errors.Wrap(err, "os.Remove")
+		}
+		return s.open()
+	}
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "os.Rename")
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return errors.Wrap(err, "os.Rename")
+	}
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// DBSink inserts decisions into the decision_logs table, shaped by
+// db.DecisionLog.
+type DBSink struct {
+	db *gorm.DB
+}
+
+func NewDBSink(gdb *gorm.DB) (*DBSink, error) {
+	if err := gdb.AutoMigrate(&db.DecisionLog{}); err != nil {
+		return nil, errors.Wrap(err, "AutoMigrate")
+	}
+	return &DBSink{db: gdb}, nil
+}
+
+func (s *DBSink) Write(decisions []Decision) error {
+	records := make([]db.DecisionLog, 0, len(decisions))
+	for _, d := range decisions {
+		records = append(records, db.DecisionLog{
+			Time:          d.Time,
+			Sub:           d.Sub,
+			Dom:           d.Dom,
+			Obj:           d.Obj,
+			Act:           d.Act,
+			Allowed:       d.Allowed,
+			MatchedRule:   strings.Join(d.MatchedRule, ", "),
+			RootClause:    d.RootClause,
+			PolicyVersion: d.PolicyVersion,
+			LatencyNs:     d.Latency.Nanoseconds(),
+		})
+	}
+	if err := s.db.Create(&records).Error; err != nil {
+		return errors.Wrap(err, "Create decision logs")
+	}
+	return nil
+}
+
+func (s *DBSink) Close() error {
+	return nil
+}
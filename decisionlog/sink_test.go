@@ -0,0 +1,128 @@
+package decisionlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readDecisionFile returns the subjects of the decisions in path.
+func readDecisionFile(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var subjects []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d Decision
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		subjects = append(subjects, d.Sub)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return subjects
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	line, err := json.Marshal(Decision{Sub: "user:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for two decisions per file.
+	maxBytes := int64(2*(len(line)+1) + 1)
+
+	tests := []struct {
+		name    string
+		backups int
+		want    map[string][]string
+	}{
+		{
+			name:    "two backups",
+			backups: 2,
+			want: map[string][]string{
+				"decisions.jsonl":   {"user:6"},
+				"decisions.jsonl.1": {"user:4", "user:5"},
+				"decisions.jsonl.2": {"user:2", "user:3"},
+			},
+		},
+		{
+			name:    "no backups",
+			backups: 0,
+			want: map[string][]string{
+				"decisions.jsonl": {"user:6"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "decisions.jsonl")
+			sink, err := NewFileSink(path, maxBytes, tt.backups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// One batch of three, then one at a time.
+			batches := [][]Decision{{{Sub: "user:0"}, {Sub: "user:1"}, {Sub: "user:2"}}}
+			for i := 3; i < 7; i++ {
+				batches = append(batches, []Decision{{Sub: fmt.Sprintf("user:%d", i)}})
+			}
+			for _, batch := range batches {
+				if err := sink.Write(batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("%d files, want %d", len(entries), len(tt.want))
+			}
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > maxBytes {
+					t.Errorf("%s has %d bytes, more than %d", entry.Name(), info.Size(), maxBytes)
+				}
+				got := readDecisionFile(t, filepath.Join(dir, entry.Name()))
+				if fmt.Sprint(got) != fmt.Sprint(tt.want[entry.Name()]) {
+					t.Errorf("%s has %v, want %v", entry.Name(), got, tt.want[entry.Name()])
+				}
+			}
+		})
+	}
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	for _, sub := range []string{"user:ian", "user:sonnie"} {
+		sink, err := NewFileSink(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Write([]Decision{{Sub: sub}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readDecisionFile(t, path); fmt.Sprint(got) != "[user:ian user:sonnie]" {
+		t.Errorf("decisions = %v", got)
+	}
+}
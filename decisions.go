@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"sync/atomic"
+
+	"casbin-playground/decisionlog"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/pkg/errors"
+)
+
+// decisionLog receives every decision enforce makes. It holds nil unless
+// -decision-log is set.
+var decisionLog atomic.Pointer[decisionlog.Logger]
+
+// Enforcer is a casbin enforcer with the decisionlog.Fingerprint of its
+// rules, which preparePolicy updates whenever the policy is loaded or
+// changed. Whoever guards the rules guards the fingerprint too.
+type Enforcer struct {
+	*casbin.Enforcer
+	version string
+}
+
+func policyVersion(e *Enforcer) string {
+	if e.version == "" {
+		// Only enforcers that were never prepared get here.
+		return decisionlog.Fingerprint(e.Enforcer)
+	}
+	return e.version
+}
+
+func policyChanged(e *Enforcer) {
+	e.version = decisionlog.Fingerprint(e.Enforcer)
+}
+
+// logDecision adds the policy version to d and logs it, when decisions are
+// logged at all.
+func logDecision(e *Enforcer, d decisionlog.Decision) {
+	logger := decisionLog.Load()
+	if logger == nil {
+		return
+	}
+	d.PolicyVersion = policyVersion(e)
+	logger.Log(d)
+}
+
+type decisionLogOptions struct {
+	target     string
+	maxSize    int64
+	backups    int
+	sampleRate float64
+	keepDenied bool
+	hashUsers  string
+}
+
+func (o *decisionLogOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.target, "decision-log", "", `log every decision to this JSONL file, or to the decision_logs table with "db"`)
+	fs.Int64Var(&o.maxSize, "decision-log-max-size", 100, "rotate the decision log file at this many MB, 0 to never rotate")
+	fs.IntVar(&o.backups, "decision-log-backups", 5, "rotated decision log files to keep")
+	fs.Float64Var(&o.sampleRate, "decision-log-sample", 1, "share of decisions to log, from 0 to 1")
+	fs.BoolVar(&o.keepDenied, "decision-log-keep-denied", false, "log every denied decision regardless of -decision-log-sample")
+	fs.StringVar(&o.hashUsers, "decision-log-hash-users", "", "replace user subjects in the decision log by hashes salted with this value")
+}
+
+// open starts decisionLog. The returned function flushes and closes it;
+// decisions made meanwhile are dropped.
+func (o *decisionLogOptions) open(opts *options) (func(), error) {
+	if o.target == "" {
+		return func() {}, nil
+	}
+	if o.sampleRate <= 0 || o.sampleRate > 1 {
+		return nil, errors.Wrap(ErrInvalidRequest, "-decision-log-sample must be above 0 and at most 1")
+	}
+
+	var sink decisionlog.Sink
+	if o.target == "db" {
+		gdb, err := opts.openDB()
+		if err != nil {
+			return nil, err
+		}
+		if sink, err = decisionlog.NewDBSink(gdb); err != nil {
+			return nil, errors.Wrap(err, "decisionlog.NewDBSink")
+		}
+	} else {
+		fileSink, err := decisionlog.NewFileSink(o.target, o.maxSize<<20, o.backups)
+		if err != nil {
+			return nil, errors.Wrap(err, "decisionlog.NewFileSink")
+		}
+		sink = fileSink
+	}
+
+	cfg := decisionlog.Config{
+		Sink:       sink,
+		SampleRate: o.sampleRate,
+		KeepDenied: o.keepDenied,
+	}
+	if o.hashUsers != "" {
+		cfg.Redactors = append(cfg.Redactors, decisionlog.HashSubjects(UserPrefix, o.hashUsers))
+	}
+	logger, err := decisionlog.New(cfg)
+	if err != nil {
+		sink.Close()
+		return nil, errors.Wrap(err, "decisionlog.New")
+	}
+	decisionLog.Store(logger)
+	return func() {
+		decisionLog.CompareAndSwap(logger, nil)
+		logger.Close()
+	}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"casbin-playground/decisionlog"
+)
+
+// memorySink keeps every decision written to it.
+type memorySink struct {
+	mu        sync.Mutex
+	decisions []decisionlog.Decision
+}
+
+func (s *memorySink) Write(decisions []decisionlog.Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, decisions...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+// logTestDecisions logs the decisions run makes and returns them.
+func logTestDecisions(t *testing.T, run func()) []decisionlog.Decision {
+	t.Helper()
+	sink := &memorySink{}
+	logger, err := decisionlog.New(decisionlog.Config{Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisionLog.Store(logger)
+	defer decisionLog.Store(nil)
+	run()
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sink.decisions
+}
+
+func TestDecisionLog(t *testing.T) {
+	ctx := context.Background()
+	e := openTestPolicy(t, `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, role:admin:0, dom:Company, obj:news, act:read, allow
+g, user:ian, role:editor:1, dom:marketing
+g, user:jason, role:root:0, dom:Company
+`)
+	before := policyVersion(e)
+
+	decisions := logTestDecisions(t, func() {
+		if _, err := BatchEnforce(ctx, e, "user:ian", []EnforceRequest{
+			{Dom: "dom:marketing", Obj: "obj:news", Act: "act:read"},
+			{Dom: "dom:marketing", Obj: "obj:news", Act: "act:delete"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Explain(ctx, e, ExplainRequest{Sub: "user:jason", EnforceRequest: EnforceRequest{Dom: "dom:Company", Obj: "obj:account", Act: "act:delete"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyPolicyDeltas(e, policyDelta{ptype: "p", added: [][]string{{"role:editor:1", "dom:marketing", "obj:news", "act:delete", "allow"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := getUserPermissionsFromPolicy(ctx, e, "user:ian", "dom:marketing"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var got []string
+	for _, d := range decisions[:3] {
+		got = append(got, fmt.Sprintf("%s %s %s %s %t %v %t", d.Sub, d.Dom, d.Obj, d.Act, d.Allowed, d.MatchedRule, d.RootClause))
+	}
+	want := []string{
+		"user:ian dom:marketing obj:news act:read true [role:editor:1 dom:marketing obj:news act:read allow] false",
+		"user:ian dom:marketing obj:news act:delete false [] false",
+		"user:jason dom:Company obj:account act:delete true [] true",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("logged\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, d := range decisions[:3] {
+		if d.PolicyVersion != before {
+			t.Errorf("%s %s logged with version %s, want %s", d.Sub, d.Act, d.PolicyVersion, before)
+		}
+	}
+
+	// The matrix logs one decision per cell, against the changed policy.
+	matrix := decisions[3:]
+	if want := len(generatePermissionsMapping(e)) * len(vocabulary.Actions()); len(matrix) != want {
+		t.Errorf("the matrix logged %d decisions, want %d", len(matrix), want)
+	}
+	after := policyVersion(e)
+	if after == before {
+		t.Errorf("the policy version did not change")
+	}
+	for _, d := range matrix {
+		if d.Sub != "user:ian" || d.PolicyVersion != after {
+			t.Errorf("matrix decision %+v, want user:ian at version %s", d, after)
+			break
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var ErrDelegationDenied = errors.New("delegation denied")
+
+// checkDelegation allows actor to assign, revoke or edit role in dom only when
+// the role's level is strictly greater than actor's own highest level there,
+// that is its smallest level number. Root in the company domain is exempt.
+func checkDelegation(e *Enforcer, actor string, role string, dom string) error {
+	if actor == "" {
+		return errors.Wrap(ErrDelegationDenied, "no actor")
+	}
+	if isRoot(e, actor, string(CompanyDom)) {
+		return nil
+	}
+
+	_, roleLevel, err := parseRole(role)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("parseRole(%s)", role))
+	}
+	actorLevel, ok := highestLevel(e, actor, dom)
+	if !ok {
+		return errors.Wrap(ErrDelegationDenied, fmt.Sprintf("%s has no role in %s", actor, dom))
+	}
+	if roleLevel <= actorLevel {
+		return errors.Wrap(ErrDelegationDenied, fmt.Sprintf("%s is level %d in %s and cannot manage %s", actor, actorLevel, dom, role))
+	}
+	return nil
+}
+
+// highestLevel returns the smallest level number among the roles actor holds
+// in dom, directly or through other roles.
+func highestLevel(e *Enforcer, actor string, dom string) (int, bool) {
+	level, ok := 0, false
+	for _, role := range resolveDomainGrant(e, actor, dom).subjects[1:] {
+		_, roleLevel, err := parseRole(role)
+		if err != nil {
+			continue
+		}
+		if !ok || roleLevel < level {
+			level, ok = roleLevel, true
+		}
+	}
+	return level, ok
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const delegationRules = `
+p, role:admin:0, dom:marketing, obj:news, act:all, allow
+p, role:admin_leader:1, dom:marketing, obj:news, act:read, allow
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+p, role:admin:1, dom:Company, obj:account, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+g, user:ian2, role:admin_leader:1, dom:marketing
+g, user:sonnie, role:admin:1, dom:Company
+`
+
+func TestCheckDelegation(t *testing.T) {
+	e := openTestPolicy(t, delegationRules)
+	tests := []struct {
+		actor string
+		role  string
+		dom   string
+		want  error
+	}{
+		{"user:jason", "role:admin:0", "dom:marketing", nil},
+		{"user:jason", "role:root:0", "dom:Company", nil},
+		{"user:ian", "role:admin_leader:1", "dom:marketing", nil},
+		{"user:ian", "role:editor:2", "dom:marketing", nil},
+		{"user:ian", "role:admin:0", "dom:marketing", ErrDelegationDenied},
+		{"user:ian2", "role:editor:2", "dom:marketing", nil},
+		{"user:ian2", "role:admin_leader:1", "dom:marketing", ErrDelegationDenied},
+		{"user:ian2", "role:admin:0", "dom:marketing", ErrDelegationDenied},
+		{"user:sonnie", "role:root:0", "dom:Company", ErrDelegationDenied},
+		{"user:sonnie", "role:editor:2", "dom:marketing", ErrDelegationDenied},
+		{"user:nobody", "role:editor:2", "dom:marketing", ErrDelegationDenied},
+		{"", "role:editor:2", "dom:marketing", ErrDelegationDenied},
+	}
+	for _, tt := range tests {
+		err := checkDelegation(e, tt.actor, tt.role, tt.dom)
+		if errors.Cause(err) != tt.want {
+			t.Errorf("checkDelegation(%q, %s, %s) = %v, want %v", tt.actor, tt.role, tt.dom, err, tt.want)
+		}
+	}
+}
+
+func TestAssignAuthenticatesActor(t *testing.T) {
+	e := openTestPolicy(t, delegationRules)
+	tokens, err := NewActorTokens(map[string]string{
+		"jason-token": "user:jason",
+		"ian-token":   "user:ian",
+		"ian2-token":  "user:ian2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(e, openTestRepository(t), nil, tokens.Authenticate).routes()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		role    string
+		want    int
+	}{
+		{"no credentials", nil, `"name": "editor", "level": 2`, http.StatusUnauthorized},
+		{"a claimed actor", map[string]string{"X-Actor": "user:jason"}, `"name": "editor", "level": 2`, http.StatusUnauthorized},
+		{"an unknown token", map[string]string{"Authorization": "Bearer guess"}, `"name": "editor", "level": 2`, http.StatusUnauthorized},
+		{"their own level", map[string]string{"Authorization": "Bearer ian2-token"}, `"name": "admin_leader", "level": 1`, http.StatusForbidden},
+		{"above their level", map[string]string{"Authorization": "Bearer ian2-token"}, `"name": "admin", "level": 0`, http.StatusForbidden},
+		{"below their level", map[string]string{"Authorization": "Bearer ian2-token"}, `"name": "editor", "level": 2`, http.StatusNoContent},
+		{"level 0 below level 0", map[string]string{"Authorization": "Bearer ian-token"}, `"name": "admin", "level": 0`, http.StatusForbidden},
+		{"root", map[string]string{"Authorization": "Bearer jason-token"}, `"name": "admin", "level": 0`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		body := `{"division": {"name": "marketing"}, ` + tt.role + `}`
+		r := httptest.NewRequest(http.MethodPost, "/users/roles/zoe", strings.NewReader(body))
+		for key, value := range tt.headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	want := []string{"role:editor:2", "role:admin:0"}
+	got := []string{}
+	for _, g := range e.GetFilteredNamedGroupingPolicy("g", 0, "user:zoe") {
+		got = append(got, g[1])
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("user:zoe has %v, want %v", got, want)
+	}
+}
+
+func TestActorTokens(t *testing.T) {
+	for _, tokens := range []map[string]string{
+		{"": "user:jason"},
+		{"token": "role:admin:0"},
+		{"token": "jason"},
+	} {
+		if _, err := NewActorTokens(tokens); errors.Cause(err) != ErrInvalidRequest {
+			t.Errorf("NewActorTokens(%v) = %v, want %v", tokens, err, ErrInvalidRequest)
+		}
+	}
+
+	var none *ActorTokens
+	if _, err := none.Subject("jason-token"); errors.Cause(err) != ErrUnauthenticated {
+		t.Errorf("Subject without tokens = %v, want %v", err, ErrUnauthenticated)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDivisionExists      = errors.New("division already exists")
+	ErrTemplateNotFound    = errors.New("division template not found")
+	ErrUnknownDivisionType = errors.New("unknown division type")
+	ErrCloneConflict       = errors.New("target rules already exist")
+)
+
+// divisionTemplates holds the roles seeded into every new division of a type.
+var divisionTemplates = defaultDivisionTemplates()
+
+func defaultDivisionTemplates() map[DivisionType][]DivisionRole {
+	return map[DivisionType][]DivisionRole{
+		DivisionTypeDivision: {
+			{
+				Name:        DivisionRoleName("admin"),
+				Level:       0,
+				Permissions: generateAllAllowPermissions(),
+			},
+		},
+		DivisionTypeGuest: {
+			{
+				Name:  DivisionRoleNameOrganiser,
+				Level: 0,
+				Permissions: []Permission{
+					limitedPermission("exhibition"),
+					limitedPermission("news"),
+				},
+			},
+		},
+	}
+}
+
+func limitedPermission(obj string) Permission {
+	return Permission{
+		Name: obj,
+		Actions: []Action{
+			{Name: "read", Status: true},
+			{Name: "create_limited", Status: true},
+			{Name: "update_limited", Status: true},
+			{Name: "delete_limited", Status: true},
+		},
+	}
+}
+
+// RegisterDivisionTemplate replaces the template used for divisionType.
+func RegisterDivisionTemplate(divisionType DivisionType, divisionRoles []DivisionRole) error {
+	if !knownDivisionType(divisionType) {
+		return errors.Wrap(ErrUnknownDivisionType, string(divisionType))
+	}
+	for _, divisionRole := range divisionRoles {
+		if divisionRole.Name == "" {
+			return errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%s template has a role without a name", divisionType))
+		}
+		if _, err := permissionsToMapping(divisionRole.Permissions); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("%s template role %s", divisionType, divisionRole.Name))
+		}
+	}
+	divisionTemplates[divisionType] = divisionRoles
+	return nil
+}
+
+// LoadDivisionTemplates registers every template in a JSON file shaped as
+// {"<DivisionType>": [DivisionRole, ...]}.
+func LoadDivisionTemplates(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "os.ReadFile")
+	}
+	var templates map[DivisionType][]DivisionRole
+	if err := json.Unmarshal(b, &templates); err != nil {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	for divisionType, divisionRoles := range templates {
+		if err := RegisterDivisionTemplate(divisionType, divisionRoles); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("RegisterDivisionTemplate(%s)", divisionType))
+		}
+	}
+	return nil
+}
+
+// CreateDivision seeds the p rules of every role in the template registered
+// for division.Type, in one step through the adapter.
+func CreateDivision(ctx context.Context, e *Enforcer, repo Repository, actor string, division Division) (*Division, error) {
+	if division.Name == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty division name")
+	}
+	if !knownDivisionType(division.Type) {
+		return nil, errors.Wrap(ErrUnknownDivisionType, string(division.Type))
+	}
+	template, ok := divisionTemplates[division.Type]
+	if !ok {
+		return nil, errors.Wrap(ErrTemplateNotFound, string(division.Type))
+	}
+	dom := DomPrefix + string(division.Name)
+	if domainExists(e, dom) {
+		return nil, errors.Wrap(ErrDivisionExists, dom)
+	}
+
+	delta := policyDelta{ptype: "p"}
+	for _, divisionRole := range template {
+		role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
+		if err := checkDelegation(e, actor, role, dom); err != nil {
+			return nil, errors.Wrap(err, "checkDelegation")
+		}
+		rules, err := permissionsToRules(e, role, dom, divisionRole.Permissions)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("permissionsToRules(%s, %s)", role, dom))
+		}
+		delta.added = append(delta.added, rules...)
+	}
+	created := Division{
+		Name: division.Name,
+		Type: division.Type,
+	}
+	for _, divisionRole := range template {
+		created.DivisionRoles = append(created.DivisionRoles, DivisionRole{
+			Name:  divisionRole.Name,
+			Level: divisionRole.Level,
+		})
+	}
+
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return nil, errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.CreateDivision(ctx, created); err != nil {
+		return nil, revertPolicyDeltas(e, errors.Wrap(err, "CreateDivision"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return nil, errors.Wrap(err, "recordPolicyDeltas")
+	}
+
+	if err := fillDivisionPermissions(ctx, e, &created); err != nil {
+		return nil, errors.Wrap(err, "fillDivisionPermissions")
+	}
+	return &created, nil
+}
+
+// permissionsToRules returns the p rules granting every allowed action in
+// permissions to role in dom.
+func permissionsToRules(e *Enforcer, role string, dom string, permissions []Permission) ([][]string, error) {
+	mPermissions, err := permissionsToMapping(permissions)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBundles(e, mPermissions); err != nil {
+		return nil, err
+	}
+
+	var rules [][]string
+	for _, obj := range matrixObjects(e) {
+		for _, act := range vocabulary.Actions() {
+			if mPermissions[obj][act] {
+				rules = append(rules, []string{role, dom, obj, act, EffectAllow})
+			}
+		}
+	}
+	return rules, nil
+}
+
+func knownDivisionType(divisionType DivisionType) bool {
+	switch divisionType {
+	case DivisionTypeCompany, DivisionTypeDivision, DivisionTypeGuest:
+		return true
+	}
+	return false
+}
+
+type RoleMapping struct {
+	From DivisionRole `json:"from"`
+	To   DivisionRole `json:"to"`
+}
+
+type CloneDivisionRequest struct {
+	Source DivisionName `json:"source"`
+	Target DivisionName `json:"target"`
+	// RoleMappings rename source roles in the target; unmapped roles keep
+	// their name and level.
+	RoleMappings []RoleMapping `json:"roleMappings,omitempty"`
+	CopyUsers    bool          `json:"copyUsers"`
+	// Force replaces the target's existing rules for the cloned roles.
+	Force bool `json:"force"`
+}
+
+type CloneDivisionResult struct {
+	Added   [][]string `json:"added"`
+	Removed [][]string `json:"removed"`
+}
+
+// CloneDivision copies every role policy of the source division into the
+// target division, and optionally the users holding those roles.
+func CloneDivision(ctx context.Context, e *Enforcer, repo Repository, actor string, req CloneDivisionRequest) (*CloneDivisionResult, error) {
+	if req.Source == "" || req.Target == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "source and target are required")
+	}
+	if req.Source == req.Target {
+		return nil, errors.Wrap(ErrInvalidRequest, "source and target are the same division")
+	}
+	sourceDom := DomPrefix + string(req.Source)
+	targetDom := DomPrefix + string(req.Target)
+	if !domainExists(e, sourceDom) {
+		return nil, errors.Wrap(ErrDomainNotFound, sourceDom)
+	}
+
+	mRoles := make(map[string]string)
+	for _, mapping := range req.RoleMappings {
+		from := fmt.Sprintf(RolePrefixFormat, mapping.From.Name, mapping.From.Level)
+		if !roleExists(e, from, sourceDom) {
+			return nil, errors.Wrap(ErrRoleNotFound, fmt.Sprintf("%s in %s", from, sourceDom))
+		}
+		if mapping.To.Name == "" {
+			return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%s is mapped to a role without a name", from))
+		}
+		mRoles[from] = fmt.Sprintf(RolePrefixFormat, mapping.To.Name, mapping.To.Level)
+	}
+	mapRole := func(role string) string {
+		if mapped, ok := mRoles[role]; ok {
+			return mapped
+		}
+		return role
+	}
+
+	pDelta := policyDelta{ptype: "p"}
+	gDelta := policyDelta{ptype: "g"}
+	targetRoles := make(map[string]bool)
+	for _, p := range e.GetFilteredPolicy(1, sourceDom) {
+		if !strings.HasPrefix(p[0], RolePrefix) {
+			continue
+		}
+		rule := append([]string{mapRole(p[0]), targetDom}, p[2:]...)
+		targetRoles[rule[0]] = true
+		pDelta.added = appendUniqueRule(pDelta.added, rule)
+	}
+	if req.CopyUsers {
+		for _, g := range e.GetFilteredNamedGroupingPolicy("g", 2, sourceDom) {
+			rule := []string{g[0], mapRole(g[1]), targetDom}
+			targetRoles[rule[1]] = true
+			gDelta.added = appendUniqueRule(gDelta.added, rule)
+		}
+	}
+
+	var existingP, existingG [][]string
+	for role := range targetRoles {
+		if err := checkDelegation(e, actor, role, targetDom); err != nil {
+			return nil, errors.Wrap(err, "checkDelegation")
+		}
+		existingP = append(existingP, e.GetFilteredPolicy(0, role, targetDom)...)
+		if req.CopyUsers {
+			existingG = append(existingG, e.GetFilteredNamedGroupingPolicy("g", 1, role, targetDom)...)
+		}
+	}
+	if !req.Force && len(existingP)+len(existingG) > 0 {
+		return nil, errors.Wrap(ErrCloneConflict, fmt.Sprintf("%d rules for the cloned roles already exist in %s", len(existingP)+len(existingG), targetDom))
+	}
+	pDelta.removed, pDelta.added = subtractRules(existingP, pDelta.added), subtractRules(pDelta.added, existingP)
+	gDelta.removed, gDelta.added = subtractRules(existingG, gDelta.added), subtractRules(gDelta.added, existingG)
+
+	if err := applyPolicyDeltas(e, pDelta, gDelta); err != nil {
+		return nil, errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := storeClonedDivision(ctx, repo, req, targetRoles, gDelta.added); err != nil {
+		return nil, revertPolicyDeltas(e, errors.Wrap(err, "storeClonedDivision"), pDelta, gDelta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, pDelta, gDelta); err != nil {
+		return nil, errors.Wrap(err, "recordPolicyDeltas")
+	}
+
+	return &CloneDivisionResult{
+		Added:   append(append([][]string{}, pDelta.added...), gDelta.added...),
+		Removed: append(append([][]string{}, pDelta.removed...), gDelta.removed...),
+	}, nil
+}
+
+// storeClonedDivision records the target division, its cloned roles and the
+// copied memberships in repo. A new target takes the source's type.
+func storeClonedDivision(ctx context.Context, repo Repository, req CloneDivisionRequest, targetRoles map[string]bool, memberships [][]string) error {
+	source, err := repo.GetDivision(ctx, req.Source)
+	if err != nil {
+		return errors.Wrap(err, "GetDivision")
+	}
+	target := &Division{Name: req.Target, Type: source.Type}
+	if _, err := repo.GetDivision(ctx, req.Target); errors.Cause(err) == ErrDivisionNotFound {
+		if err := repo.CreateDivision(ctx, *target); err != nil {
+			return errors.Wrap(err, "CreateDivision")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "GetDivision")
+	}
+
+	for role := range targetRoles {
+		name, level, err := parseRole(role)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("parseRole(%s)", role))
+		}
+		divisionRole := DivisionRole{Division: target, Name: name, Level: level}
+		if err := repo.CreateDivisionRole(ctx, divisionRole); err != nil {
+			return errors.Wrap(err, "CreateDivisionRole")
+		}
+	}
+	for _, g := range memberships {
+		if !strings.HasPrefix(g[0], UserPrefix) {
+			continue
+		}
+		name, level, err := parseRole(g[1])
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("parseRole(%s)", g[1]))
+		}
+		divisionRole := DivisionRole{Division: target, Name: name, Level: level}
+		if err := repo.AddUserDivisionRole(ctx, strings.TrimPrefix(g[0], UserPrefix), divisionRole); err != nil {
+			return errors.Wrap(err, "AddUserDivisionRole")
+		}
+	}
+	return nil
+}
+
+func appendUniqueRule(rules [][]string, rule []string) [][]string {
+	for _, r := range rules {
+		if equalRule(r, rule) {
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// subtractRules returns the rules of a that are not in b.
+func subtractRules(a [][]string, b [][]string) [][]string {
+	var rules [][]string
+	for _, rule := range a {
+		if !containsRule(b, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func containsRule(rules [][]string, rule []string) bool {
+	for _, r := range rules {
+		if equalRule(r, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalRule(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const divisionRules = `
+p, role:admin:0, dom:marketing, obj:news, act:all, allow
+g, user:jason, role:root:0, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+`
+
+func TestCreateDivision(t *testing.T) {
+	e := openTestPolicy(t, divisionRules)
+	repo := openTestRepository(t)
+	ctx := context.Background()
+
+	created, err := CreateDivision(ctx, e, repo, "user:jason", Division{Name: "expo", Type: DivisionTypeGuest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for _, obj := range []string{"exhibition", "news"} {
+		for _, act := range []string{"create_limited", "delete_limited", "read", "update_limited"} {
+			want = append(want, fmt.Sprintf("role:organiser:0, dom:expo, obj:%s, act:%s, allow", obj, act))
+		}
+	}
+	if got := deltaLines(e.GetFilteredPolicy(1, "dom:expo")); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rules of dom:expo:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := fmt.Sprint(roleNames(created.DivisionRoles)); got != "[organiser:0]" {
+		t.Errorf("created roles = %s", got)
+	}
+	stored, err := repo.GetDivision(ctx, "expo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Type != DivisionTypeGuest || fmt.Sprint(roleNames(stored.DivisionRoles)) != "[organiser:0]" {
+		t.Errorf("stored division = %s %v", stored.Type, roleNames(stored.DivisionRoles))
+	}
+
+	tests := []struct {
+		name     string
+		actor    string
+		division Division
+		err      error
+	}{
+		{"existing domain", "user:jason", Division{Name: "marketing", Type: DivisionTypeDivision}, ErrDivisionExists},
+		{"no name", "user:jason", Division{Type: DivisionTypeDivision}, ErrInvalidRequest},
+		{"unknown type", "user:jason", Division{Name: "sales", Type: "shop"}, ErrUnknownDivisionType},
+		{"no template", "user:jason", Division{Name: "holding", Type: DivisionTypeCompany}, ErrTemplateNotFound},
+		{"not root", "user:ian", Division{Name: "sales", Type: DivisionTypeDivision}, ErrDelegationDenied},
+	}
+	for _, tt := range tests {
+		before := deltaLines(e.GetPolicy())
+		if _, err := CreateDivision(ctx, e, repo, tt.actor, tt.division); errors.Cause(err) != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if after := deltaLines(e.GetPolicy()); strings.Join(after, "\n") != strings.Join(before, "\n") {
+			t.Errorf("%s: the policy changed", tt.name)
+		}
+	}
+}
+
+func TestRegisterDivisionTemplate(t *testing.T) {
+	defer func() { divisionTemplates = defaultDivisionTemplates() }()
+
+	tests := []struct {
+		name         string
+		divisionType DivisionType
+		roles        []DivisionRole
+		err          error
+	}{
+		{"valid", DivisionTypeCompany, []DivisionRole{{Name: "admin", Level: 1, Permissions: []Permission{limitedPermission("news")}}}, nil},
+		{"unknown type", "shop", nil, ErrUnknownDivisionType},
+		{"role without a name", DivisionTypeDivision, []DivisionRole{{Level: 1}}, ErrInvalidRequest},
+		{"unknown object", DivisionTypeDivision, []DivisionRole{{Name: "admin", Permissions: []Permission{limitedPermission("invoice")}}}, ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		if err := RegisterDivisionTemplate(tt.divisionType, tt.roles); errors.Cause(err) != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if got := fmt.Sprint(roleNames(divisionTemplates[DivisionTypeCompany])); got != "[admin:1]" {
+		t.Errorf("company template = %s", got)
+	}
+	if got := fmt.Sprint(roleNames(divisionTemplates[DivisionTypeDivision])); got != "[admin:0]" {
+		t.Errorf("a rejected template replaced the division template: %s", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(path, []byte(`{"guest": [{"name": "host", "level": 1, "permissions": [{"name": "news", "actions": [{"name": "read", "status": true}]}]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDivisionTemplates(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(roleNames(divisionTemplates[DivisionTypeGuest])); got != "[host:1]" {
+		t.Errorf("guest template = %s", got)
+	}
+}
+
+func TestCloneDivision(t *testing.T) {
+	const rules = `
+p, role:admin:0, dom:marketing, obj:news, act:all, allow
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+p, user:ian, dom:marketing, obj:news, act:delete, deny
+p, role:admin:0, dom:sales, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+g, user:zoe, role:editor:2, dom:marketing
+`
+	tests := []struct {
+		name    string
+		actor   string
+		req     CloneDivisionRequest
+		added   []string
+		removed []string
+		err     error
+	}{
+		{
+			name:  "roles only",
+			actor: "user:jason",
+			req:   CloneDivisionRequest{Source: "marketing", Target: "expo"},
+			added: []string{
+				"role:admin:0, dom:expo, obj:news, act:all, allow",
+				"role:editor:2, dom:expo, obj:news, act:read, allow",
+			},
+			removed: []string{},
+		},
+		{
+			name:  "remapped roles and users",
+			actor: "user:jason",
+			req: CloneDivisionRequest{
+				Source:       "marketing",
+				Target:       "expo",
+				RoleMappings: []RoleMapping{{From: DivisionRole{Name: "editor", Level: 2}, To: DivisionRole{Name: "writer", Level: 3}}},
+				CopyUsers:    true,
+			},
+			added: []string{
+				"role:admin:0, dom:expo, obj:news, act:all, allow",
+				"role:writer:3, dom:expo, obj:news, act:read, allow",
+				"user:ian, role:admin:0, dom:expo",
+				"user:zoe, role:writer:3, dom:expo",
+			},
+			removed: []string{},
+		},
+		{
+			name:  "existing target rules",
+			actor: "user:jason",
+			req:   CloneDivisionRequest{Source: "marketing", Target: "sales"},
+			err:   ErrCloneConflict,
+		},
+		{
+			name:  "forced over existing target rules",
+			actor: "user:jason",
+			req:   CloneDivisionRequest{Source: "marketing", Target: "sales", Force: true},
+			added: []string{
+				"role:admin:0, dom:sales, obj:news, act:all, allow",
+				"role:editor:2, dom:sales, obj:news, act:read, allow",
+			},
+			removed: []string{"role:admin:0, dom:sales, obj:news, act:read, allow"},
+		},
+		{
+			name:  "actor at the cloned level",
+			actor: "user:ian",
+			req:   CloneDivisionRequest{Source: "marketing", Target: "expo"},
+			err:   ErrDelegationDenied,
+		},
+		{
+			name:  "same division",
+			actor: "user:jason",
+			req:   CloneDivisionRequest{Source: "marketing", Target: "marketing"},
+			err:   ErrInvalidRequest,
+		},
+		{
+			name:  "unknown source",
+			actor: "user:jason",
+			req:   CloneDivisionRequest{Source: "support", Target: "expo"},
+			err:   ErrDomainNotFound,
+		},
+		{
+			name:  "mapping an unknown role",
+			actor: "user:jason",
+			req: CloneDivisionRequest{Source: "marketing", Target: "expo",
+				RoleMappings: []RoleMapping{{From: DivisionRole{Name: "writer", Level: 2}, To: DivisionRole{Name: "editor", Level: 2}}}},
+			err: ErrRoleNotFound,
+		},
+		{
+			name:  "mapping to no name",
+			actor: "user:jason",
+			req: CloneDivisionRequest{Source: "marketing", Target: "expo",
+				RoleMappings: []RoleMapping{{From: DivisionRole{Name: "editor", Level: 2}, To: DivisionRole{Level: 3}}}},
+			err: ErrInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := openTestPolicy(t, rules)
+			repo := openTestRepository(t)
+			ctx := context.Background()
+			before := deltaLines(e.GetPolicy())
+
+			result, err := CloneDivision(ctx, e, repo, tt.actor, tt.req)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if after := deltaLines(e.GetPolicy()); strings.Join(after, "\n") != strings.Join(before, "\n") {
+					t.Error("the policy changed")
+				}
+				return
+			}
+			if got := deltaLines(result.Added); strings.Join(got, "\n") != strings.Join(tt.added, "\n") {
+				t.Errorf("added:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.added, "\n"))
+			}
+			if got := deltaLines(result.Removed); strings.Join(got, "\n") != strings.Join(tt.removed, "\n") {
+				t.Errorf("removed:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.removed, "\n"))
+			}
+
+			target, err := repo.GetDivision(ctx, tt.req.Target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.Type != DivisionTypeDivision {
+				t.Errorf("target type = %q, want %q", target.Type, DivisionTypeDivision)
+			}
+		})
+	}
+}
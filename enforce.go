@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"casbin-playground/decisionlog"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Values of the eft column of p rules.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+type EnforceRequest struct {
+	Dom string `json:"dom"`
+	Obj string `json:"obj"`
+	Act string `json:"act"`
+}
+
+type BatchEnforceRequest struct {
+	Sub    string           `json:"sub"`
+	Checks []EnforceRequest `json:"checks"`
+}
+
+type BatchEnforceResponse struct {
+	Results []bool `json:"results"`
+}
+
+// domainGrant is every subject sub acts as within dom, as the g rules link
+// them. It explains decisions; e decides them.
+type domainGrant struct {
+	dom      string
+	subjects []string
+	// links are the g rules walked from sub, in the order they were found.
+	links [][]string
+	// inherited are the roles added to subjects by level inheritance.
+	inherited []string
+}
+
+// BatchEnforce answers one decision per request for sub, in request order.
+// The roles of sub are resolved once per domain of reqs, and every request is
+// then decided by an enforcer over the rules of e that looks them up instead
+// of walking the g rules again. Decisions are logged as decisions of e.
+func BatchEnforce(ctx context.Context, e *Enforcer, sub string, reqs []EnforceRequest) ([]bool, error) {
+	if sub == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty subject")
+	}
+	var grants []*domainGrant
+	resolved := make(map[string]bool)
+	for i, req := range reqs {
+		if err := validateEnforceRequest(req); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("checks[%d]", i))
+		}
+		if !resolved[req.Dom] {
+			resolved[req.Dom] = true
+			grants = append(grants, resolveDomainGrant(e, sub, req.Dom))
+		}
+	}
+
+	batch, err := grantEnforcer(e, grants)
+	if err != nil {
+		return nil, errors.Wrap(err, "grantEnforcer")
+	}
+	results := make([]bool, len(reqs))
+	for i, req := range reqs {
+		d, err := decisionlog.Decide(batch, rootClause, sub, req.Dom, req.Obj, req.Act)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("checks[%d]: EnforceEx(%s, %s, %s, %s)", i, sub, req.Dom, req.Obj, req.Act))
+		}
+		logDecision(e, d)
+		results[i] = d.Allowed
+	}
+	return results, nil
+}
+
+// grantEnforcer returns an enforcer sharing the model and rules of e whose
+// role manager for g only links the subject of each grant directly to the
+// subjects it acts as in that domain. Requests for those subjects and
+// domains are decided as e decides them; g2 and g3 are e's own.
+func grantEnforcer(e *Enforcer, grants []*domainGrant) (*casbin.Enforcer, error) {
+	// The assertions are copied because the enforcer replaces their role
+	// managers; the rules themselves are shared.
+	m := make(model.Model, len(e.GetModel()))
+	for sec, assertions := range e.GetModel() {
+		m[sec] = make(model.AssertionMap, len(assertions))
+		for ptype, assertion := range assertions {
+			copied := *assertion
+			m[sec][ptype] = &copied
+		}
+	}
+	batch, err := casbin.NewEnforcer(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "casbin.NewEnforcer")
+	}
+	for ptype, assertion := range m["g"] {
+		if ptype == "g" {
+			continue
+		}
+		assertion.RM = e.GetNamedRoleManager(ptype)
+		batch.SetNamedRoleManager(ptype, assertion.RM)
+	}
+
+	rm := batch.GetRoleManager()
+	for _, grant := range grants {
+		for _, subject := range grant.subjects[1:] {
+			if err := rm.AddLink(grant.subjects[0], subject, grant.dom); err != nil {
+				return nil, errors.Wrap(err, "AddLink")
+			}
+		}
+	}
+	return batch, nil
+}
+
+func validateEnforceRequest(req EnforceRequest) error {
+	for _, field := range []struct {
+		value    string
+		prefixes []string
+	}{
+		{req.Dom, []string{DomPrefix}},
+		{req.Obj, []string{ObjPrefix, BundlePrefix}},
+		{req.Act, []string{ActPrefix}},
+	} {
+		if !hasNamePrefix(field.value, field.prefixes) {
+			return errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%q must start with %s", field.value, strings.Join(field.prefixes, " or ")))
+		}
+	}
+	return nil
+}
+
+// hasNamePrefix reports whether value is one of prefixes followed by a name.
+func hasNamePrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) && value != prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveDomainGrant(e *Enforcer, sub string, dom string) *domainGrant {
+	grant := &domainGrant{
+		dom:      dom,
+		subjects: []string{sub},
+	}
+
+	seen := map[string]bool{sub: true}
+	for i := 0; i < len(grant.subjects); i++ {
+		for _, g := range e.GetFilteredNamedGroupingPolicy("g", 0, grant.subjects[i], "", dom) {
+			grant.links = append(grant.links, g)
+			if role := g[1]; !seen[role] {
+				seen[role] = true
+				grant.subjects = append(grant.subjects, role)
+			}
+		}
+	}
+
+	if levelInheritance {
+		grant.inherited = inheritedRoles(e, dom, grant.subjects)
+		grant.subjects = append(grant.subjects, grant.inherited...)
+	}
+	return grant
+}
+
+// decision is how the model decided one request.
+type decision struct {
+	allowed bool
+	// rule is the p rule that decided, or nil when none matched or the root
+	// clause did.
+	rule []string
+	// root is set when the matcher's root clause allowed the request.
+	root bool
+}
+
+// enforce decides sub/dom/obj/act with e, as the middleware does, works out
+// which branch of the matcher decided and logs the decision to decisionLog.
+func enforce(e *Enforcer, sub string, dom string, obj string, act string) (decision, error) {
+	d, err := decisionlog.Decide(e.Enforcer, rootClause, sub, dom, obj, act)
+	if err != nil {
+		return decision{}, errors.Wrap(err, fmt.Sprintf("EnforceEx(%s, %s, %s, %s)", sub, dom, obj, act))
+	}
+	logDecision(e, d)
+	return decision{allowed: d.Allowed, rule: d.MatchedRule, root: d.RootClause}, nil
+}
+
+// isRoot mirrors the root clause of the matcher: sub is or holds RootRole and
+// dom is CompanyDom.
+func isRoot(e *Enforcer, sub string, dom string) bool {
+	return rootClause(e.Enforcer, sub, dom)
+}
+
+// rootClause is isRoot for any casbin enforcer of the model, as
+// decisionlog.Decide takes it.
+func rootClause(e *casbin.Enforcer, sub string, dom string) bool {
+	if dom != string(CompanyDom) {
+		return false
+	}
+	ok, err := e.GetRoleManager().HasLink(sub, string(RootRole), dom)
+	return err == nil && ok
+}
+
+// ruleCovers reports whether p rule applies to obj/act, directly or through
+// a bundle or action group.
+func ruleCovers(e *Enforcer, rule []string, obj string, act string) bool {
+	return impliesObject(e, rule[2], obj) && impliesAction(e, rule[3], act)
+}
+
+// permissions decides every object, bundle and action of the matrix for the
+// subject of g with e. mInherited marks the entries allowed by a rule of an
+// inherited role.
+func (g *domainGrant) permissions(e *Enforcer) (mPermissions map[string]map[string]bool, mInherited map[string]map[string]bool, err error) {
+	inherited := make(map[string]bool, len(g.inherited))
+	for _, role := range g.inherited {
+		inherited[role] = true
+	}
+
+	mPermissions = generatePermissionsMapping(e)
+	mInherited = make(map[string]map[string]bool)
+	for obj, mAct := range mPermissions {
+		for act := range mAct {
+			d, err := enforce(e, g.subjects[0], g.dom, obj, act)
+			if err != nil {
+				return nil, nil, err
+			}
+			mAct[act] = d.allowed
+			if d.allowed && d.rule != nil && inherited[d.rule[0]] {
+				if _, ok := mInherited[obj]; !ok {
+					mInherited[obj] = make(map[string]bool)
+				}
+				mInherited[obj][act] = true
+			}
+		}
+	}
+	return mPermissions, mInherited, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// groupRules are the action groups of policy_my.csv.
+const groupRules = `
+g3, act:read, act:all
+g3, act:create, act:all
+g3, act:update, act:all
+g3, act:delete, act:all
+g3, act:create_limited, act:create
+g3, act:update_limited, act:update
+g3, act:delete_limited, act:delete
+`
+
+func openTestPolicy(t *testing.T, rules string) *Enforcer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(rules+groupRules)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := openPolicySource(DefaultModelPath, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// matrixStatus returns the status of obj/act in the matrix of sub, which is
+// built as a role matrix when sub is a role and as a user matrix otherwise.
+func matrixStatus(t *testing.T, e *Enforcer, sub string, dom string, obj string, act string) bool {
+	t.Helper()
+	build := getUserPermissionsFromPolicy
+	if strings.HasPrefix(sub, RolePrefix) {
+		build = getRolePermissionsFromPolicy
+	}
+	permissions, err := build(context.Background(), e, sub, dom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, permission := range permissions {
+		if permissionObject(permission.Name) != obj {
+			continue
+		}
+		for _, action := range permission.Actions {
+			if ActPrefix+action.Name == act {
+				return action.Status
+			}
+		}
+	}
+	t.Fatalf("%s/%s missing from the matrix of %s", obj, act, sub)
+	return false
+}
+
+func TestDenyPrecedence(t *testing.T) {
+	type check struct {
+		sub  string
+		obj  string
+		act  string
+		want bool
+	}
+	tests := []struct {
+		name   string
+		rules  string
+		checks []check
+	}{
+		{
+			name: "user deny over role allow",
+			rules: `
+p, role:editor:1, dom:Company, obj:news, act:read, allow
+p, user:alice, dom:Company, obj:news, act:read, deny
+g, user:alice, role:editor:1, dom:Company
+g, user:bob, role:editor:1, dom:Company
+`,
+			checks: []check{
+				{"user:alice", "obj:news", "act:read", false},
+				{"user:bob", "obj:news", "act:read", true},
+				{"role:editor:1", "obj:news", "act:read", true},
+			},
+		},
+		{
+			name: "role deny vs user allow",
+			rules: `
+p, role:editor:1, dom:Company, obj:news, act:read, deny
+p, user:alice, dom:Company, obj:news, act:read, allow
+g, user:alice, role:editor:1, dom:Company
+g, user:bob, role:editor:1, dom:Company
+`,
+			checks: []check{
+				{"user:alice", "obj:news", "act:read", true},
+				{"user:bob", "obj:news", "act:read", false},
+				{"role:editor:1", "obj:news", "act:read", false},
+			},
+		},
+		{
+			name: "deny on an action group",
+			rules: `
+p, role:editor:1, dom:Company, obj:news, act:all, allow
+p, user:alice, dom:Company, obj:news, act:create, deny
+g, user:alice, role:editor:1, dom:Company
+`,
+			checks: []check{
+				{"user:alice", "obj:news", "act:create", false},
+				{"user:alice", "obj:news", "act:create_limited", false},
+				{"user:alice", "obj:news", "act:read", true},
+				{"user:alice", "obj:news", "act:update_limited", true},
+				{"role:editor:1", "obj:news", "act:create_limited", true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := openTestPolicy(t, tt.rules)
+			for _, c := range tt.checks {
+				allowed, err := e.Enforce(c.sub, string(CompanyDom), c.obj, c.act)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != c.want {
+					t.Errorf("Enforce(%s, %s, %s) = %v, want %v", c.sub, c.obj, c.act, allowed, c.want)
+				}
+				if got := matrixStatus(t, e, c.sub, string(CompanyDom), c.obj, c.act); got != c.want {
+					t.Errorf("matrix of %s: %s/%s = %v, want %v", c.sub, c.obj, c.act, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchEnforce(t *testing.T) {
+	tests := []struct {
+		name        string
+		inheritance bool
+		rules       string
+		sub         string
+		checks      []EnforceRequest
+		want        []bool
+	}{
+		{
+			name: "roles, denies and bundles across domains",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:all, allow
+p, user:alice, dom:marketing, obj:news, act:delete, deny
+p, role:member:2, dom:Company, subscription:media, act:read, allow
+g, user:alice, role:editor:1, dom:marketing
+g, user:alice, role:lead:1, dom:Company
+g, role:lead:1, role:member:2, dom:Company
+g2, obj:news, subscription:media
+`,
+			sub: "user:alice",
+			checks: []EnforceRequest{
+				{"dom:marketing", "obj:news", "act:read"},
+				{"dom:Company", "obj:news", "act:read"},
+				{"dom:marketing", "obj:news", "act:delete_limited"},
+				{"dom:Company", "subscription:media", "act:read"},
+				{"dom:Company", "obj:news", "act:update"},
+				{"dom:marketing", "obj:news", "act:update_limited"},
+				{"dom:sales", "obj:news", "act:read"},
+			},
+			want: []bool{true, true, false, true, false, true, false},
+		},
+		{
+			name: "root only in dom:Company",
+			rules: `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+p, user:jason, dom:Company, obj:news, act:delete, deny
+g, user:jason, role:root:0, dom:Company
+`,
+			sub: "user:jason",
+			checks: []EnforceRequest{
+				{"dom:Company", "obj:news", "act:delete"},
+				{"dom:Company", "obj:account", "act:create"},
+				{"dom:marketing", "obj:news", "act:read"},
+			},
+			want: []bool{true, true, false},
+		},
+		{
+			name:        "level inheritance",
+			inheritance: true,
+			rules: `
+p, role:admin_member:2, dom:Company, obj:news, act:read, allow
+p, role:admin:1, dom:Company, obj:news, act:update, deny
+g, user:sonnie, role:admin:1, dom:Company
+`,
+			sub: "user:sonnie",
+			checks: []EnforceRequest{
+				{"dom:Company", "obj:news", "act:read"},
+				{"dom:Company", "obj:news", "act:update"},
+				{"dom:Company", "obj:news", "act:create"},
+			},
+			want: []bool{true, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			levelInheritance = tt.inheritance
+			defer func() { levelInheritance = false }()
+			e := openTestPolicy(t, tt.rules)
+
+			got, err := BatchEnforce(context.Background(), e, tt.sub, tt.checks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, check := range tt.checks {
+				allowed, err := e.Enforce(tt.sub, check.Dom, check.Obj, check.Act)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got[i] != tt.want[i] || got[i] != allowed {
+					t.Errorf("%v: batch %v, enforcer %v, want %v", check, got[i], allowed, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGrantEnforcer(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:member:2, dom:Company, obj:news, act:read, allow
+g, user:alice, role:lead:1, dom:Company
+g, role:lead:1, role:member:2, dom:Company
+g, user:alice, role:editor:1, dom:marketing
+g2, obj:news, subscription:media
+`)
+	grants := []*domainGrant{resolveDomainGrant(e, "user:alice", "dom:Company")}
+	batch, err := grantEnforcer(e, grants)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every role is one link away, and only the resolved domains are known.
+	roles, err := batch.GetRoleManager().GetRoles("user:alice", "dom:Company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(roles)
+	if strings.Join(roles, " ") != "role:lead:1 role:member:2" {
+		t.Errorf("roles in dom:Company = %v", roles)
+	}
+	if roles, _ := batch.GetRoleManager().GetRoles("user:alice", "dom:marketing"); len(roles) != 0 {
+		t.Errorf("roles in dom:marketing = %v, want none", roles)
+	}
+
+	// e keeps its own role managers.
+	if e.GetModel()["g"]["g"].RM != e.GetRoleManager() || e.GetModel()["g"]["g2"].RM != e.GetNamedRoleManager("g2") {
+		t.Error("grantEnforcer replaced a role manager of e")
+	}
+	if ok, _ := e.GetRoleManager().HasLink("user:alice", "role:editor:1", "dom:marketing"); !ok {
+		t.Error("e lost its g links")
+	}
+	if ok, _ := batch.GetNamedRoleManager("g2").HasLink("obj:news", "subscription:media"); !ok {
+		t.Error("the batch enforcer lost the g2 links")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+type DenyReason string
+
+const (
+	DenyReasonUnknownDomain    DenyReason = "unknown_domain"
+	DenyReasonUnknownObject    DenyReason = "unknown_object"
+	DenyReasonUnknownAction    DenyReason = "unknown_action"
+	DenyReasonNoRoleInDomain   DenyReason = "no_role_in_domain"
+	DenyReasonNoMatchingPolicy DenyReason = "no_matching_policy"
+	DenyReasonExplicitDeny     DenyReason = "explicit_deny"
+)
+
+type ExplainRequest struct {
+	Sub string `json:"sub"`
+	EnforceRequest
+}
+
+type Explanation struct {
+	ExplainRequest
+	Allowed bool `json:"allowed"`
+	// RoleLinks are the g rules that connect sub to its roles in dom.
+	RoleLinks [][]string `json:"roleLinks"`
+	// InheritedRoles are the roles whose grants apply through level inheritance.
+	InheritedRoles []string `json:"inheritedRoles,omitempty"`
+	// RootClause is set when the matcher's root clause granted the request.
+	RootClause bool `json:"rootClause"`
+	// MatchedRule is the p rule e.EnforceEx reports as deciding. It is empty
+	// when nothing matched or the root clause decided.
+	MatchedRule []string     `json:"matchedRule,omitempty"`
+	DenyReasons []DenyReason `json:"denyReasons,omitempty"`
+}
+
+func Explain(ctx context.Context, e *Enforcer, req ExplainRequest) (*Explanation, error) {
+	if req.Sub == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty subject")
+	}
+	if err := validateEnforceRequest(req.EnforceRequest); err != nil {
+		return nil, err
+	}
+
+	d, err := enforce(e, req.Sub, req.Dom, req.Obj, req.Act)
+	if err != nil {
+		return nil, err
+	}
+	grant := resolveDomainGrant(e, req.Sub, req.Dom)
+	explanation := &Explanation{
+		ExplainRequest: req,
+		Allowed:        d.allowed,
+		RoleLinks:      grant.links,
+		InheritedRoles: grant.inherited,
+		RootClause:     d.root,
+		MatchedRule:    d.rule,
+	}
+	if explanation.RoleLinks == nil {
+		explanation.RoleLinks = [][]string{}
+	}
+	if d.allowed {
+		return explanation, nil
+	}
+
+	if !domainExists(e, req.Dom) {
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonUnknownDomain)
+	}
+	if !knownObject(e, req.Obj) {
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonUnknownObject)
+	}
+	if !knownAction(e, req.Act) {
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonUnknownAction)
+	}
+
+	switch {
+	case d.rule != nil:
+		// A denied request only has a matched rule when a deny rule decided.
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonExplicitDeny)
+	case len(grant.links) == 0:
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonNoRoleInDomain, DenyReasonNoMatchingPolicy)
+	default:
+		explanation.DenyReasons = append(explanation.DenyReasons, DenyReasonNoMatchingPolicy)
+	}
+	return explanation, nil
+}
+
+// ruleEffect returns the eft column of a p rule, or EffectAllow when the model
+// does not define one.
+func ruleEffect(e *Enforcer, rule []string) string {
+	for i, token := range e.GetModel()["p"]["p"].Tokens {
+		if token == "p_eft" && i < len(rule) {
+			return rule[i]
+		}
+	}
+	return EffectAllow
+}
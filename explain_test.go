@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	// sonnie's deny comes after the allow of its role in the file;
+	// SortPoliciesBySubjectHierarchy moves it first so that it decides.
+	e := openTestPolicy(t, `
+p, role:admin:1, dom:Company, obj:account, act:delete, allow
+p, role:admin:1, dom:Company, obj:news, act:read, allow
+p, user:sonnie, dom:Company, obj:account, act:delete, deny
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:sonnie, role:admin:1, dom:Company
+g, user:ian, role:admin:1, dom:Company
+`)
+	tests := []struct {
+		name    string
+		sub     string
+		dom     string
+		obj     string
+		act     string
+		allowed bool
+		root    bool
+		links   string
+		rule    string
+		reasons string
+	}{
+		{
+			name: "allowed through a role", sub: "user:ian", dom: "dom:Company", obj: "obj:account", act: "act:delete",
+			allowed: true,
+			links:   "[[user:ian role:admin:1 dom:Company]]",
+			rule:    "[role:admin:1 dom:Company obj:account act:delete allow]",
+			reasons: "[]",
+		},
+		{
+			name: "deny overrides the role's allow", sub: "user:sonnie", dom: "dom:Company", obj: "obj:account", act: "act:delete",
+			links:   "[[user:sonnie role:admin:1 dom:Company]]",
+			rule:    "[user:sonnie dom:Company obj:account act:delete deny]",
+			reasons: "[explicit_deny]",
+		},
+		{
+			name: "the deny leaves other grants alone", sub: "user:sonnie", dom: "dom:Company", obj: "obj:news", act: "act:read",
+			allowed: true,
+			links:   "[[user:sonnie role:admin:1 dom:Company]]",
+			rule:    "[role:admin:1 dom:Company obj:news act:read allow]",
+			reasons: "[]",
+		},
+		{
+			name: "no rule matches", sub: "user:sonnie", dom: "dom:Company", obj: "obj:news", act: "act:update",
+			links:   "[[user:sonnie role:admin:1 dom:Company]]",
+			rule:    "[]",
+			reasons: "[no_matching_policy]",
+		},
+		{
+			name: "no role in the domain", sub: "user:ian", dom: "dom:marketing", obj: "obj:news", act: "act:read",
+			links:   "[]",
+			rule:    "[]",
+			reasons: "[no_role_in_domain no_matching_policy]",
+		},
+		{
+			name: "unknown domain", sub: "user:ian", dom: "dom:sales", obj: "obj:news", act: "act:read",
+			links:   "[]",
+			rule:    "[]",
+			reasons: "[unknown_domain no_role_in_domain no_matching_policy]",
+		},
+		{
+			name: "root clause", sub: "user:jason", dom: "dom:Company", obj: "obj:account", act: "act:delete",
+			allowed: true,
+			root:    true,
+			links:   "[[user:jason role:root:0 dom:Company]]",
+			rule:    "[]",
+			reasons: "[]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ExplainRequest{Sub: tt.sub, EnforceRequest: EnforceRequest{Dom: tt.dom, Obj: tt.obj, Act: tt.act}}
+			got, err := Explain(context.Background(), e, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Allowed != tt.allowed || got.RootClause != tt.root {
+				t.Errorf("allowed %t, root clause %t, want %t, %t", got.Allowed, got.RootClause, tt.allowed, tt.root)
+			}
+			if links := fmt.Sprint(got.RoleLinks); links != tt.links {
+				t.Errorf("role links %s, want %s", links, tt.links)
+			}
+			if rule := fmt.Sprint(got.MatchedRule); rule != tt.rule {
+				t.Errorf("matched rule %s, want %s", rule, tt.rule)
+			}
+			if reasons := fmt.Sprint(got.DenyReasons); reasons != tt.reasons {
+				t.Errorf("deny reasons %s, want %s", reasons, tt.reasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ExportRules reads every rule of the casbin_rule table of gdb, in the order
+// they were stored. A database without the table has no rules.
+func ExportRules(ctx context.Context, gdb *gorm.DB) ([]CSVRule, error) {
+	if !gdb.Migrator().HasTable(&db.CasbinRule{}) {
+		return nil, nil
+	}
+	var rows []db.CasbinRule
+	if err := gdb.WithContext(ctx).Order("id").Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "Find")
+	}
+	rules := make([]CSVRule, 0, len(rows))
+	for _, row := range rows {
+		rules = append(rules, CSVRule{Ptype: row.Ptype, Rule: row.Values()})
+	}
+	return rules, nil
+}
+
+// readPolicySource reads the rules of a CSV file or of the casbin_rule table
+// of a database source.
+func readPolicySource(ctx context.Context, source string) ([]CSVRule, error) {
+	gdb, err := policyDB(source)
+	if err != nil {
+		return nil, err
+	}
+	if gdb != nil {
+		return ExportRules(ctx, gdb)
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.Open")
+	}
+	defer f.Close()
+	return readPolicyCSV(f)
+}
+
+// sortRules orders rules by policy type, p types first, then by domain and
+// subject. Rules of one subject keep their order, which decides between
+// its allow and deny rules on the same permission.
+func sortRules(m model.Model, rules []CSVRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Ptype != b.Ptype {
+			return ptypeRank(m, a.Ptype) < ptypeRank(m, b.Ptype)
+		}
+		if domA, domB := ruleDomain(m, a), ruleDomain(m, b); domA != domB {
+			return domA < domB
+		}
+		return ruleSubject(a) < ruleSubject(b)
+	})
+}
+
+// ptypeRank puts p types before g types, each in name order.
+func ptypeRank(m model.Model, ptype string) string {
+	if _, ok := m["p"][ptype]; ok {
+		return "0" + ptype
+	}
+	return "1" + ptype
+}
+
+// ruleDomain returns the value of the dom field of p rules and the third
+// value of g rules with a domain. Rules without a domain return "".
+func ruleDomain(m model.Model, rule CSVRule) string {
+	assertion := ruleAssertion(m, rule.Ptype)
+	if assertion == nil {
+		return ""
+	}
+	index := -1
+	if _, ok := m["p"][rule.Ptype]; ok {
+		for i, token := range assertion.Tokens {
+			if token == rule.Ptype+"_dom" {
+				index = i
+			}
+		}
+	} else if len(assertion.Tokens) > 2 {
+		index = 2
+	}
+	if index < 0 || index >= len(rule.Rule) {
+		return ""
+	}
+	return rule.Rule[index]
+}
+
+func ruleSubject(rule CSVRule) string {
+	if len(rule.Rule) == 0 {
+		return ""
+	}
+	return rule.Rule[0]
+}
+
+// writePolicyCSV writes rules in the file adapter format, with a blank line
+// wherever the policy type or domain changes. Values are quoted as CSV where
+// needed, the way the file adapter reads them back.
+func writePolicyCSV(w io.Writer, m model.Model, rules []CSVRule) error {
+	writer := csv.NewWriter(w)
+	for i, rule := range rules {
+		if i > 0 && (rule.Ptype != rules[i-1].Ptype || ruleDomain(m, rule) != ruleDomain(m, rules[i-1])) {
+			// A record without fields is a blank line.
+			if err := writer.Write(nil); err != nil {
+				return err
+			}
+		}
+		if err := writer.Write(append([]string{rule.Ptype}, rule.Rule...)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// runPolicyExport implements "policy export". It writes the rules of the
+// policy source sorted and grouped, as CSV the file adapter can load or as
+// JSON.
+func runPolicyExport(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("policy export", flag.ExitOnError)
+	opts.register(fs)
+	out := fs.String("out", "", "file to write instead of standard output")
+	format := fs.String("format", "csv", "output format, csv or json")
+	fs.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		return fail("policy export", errors.Wrap(ErrInvalidRequest, fmt.Sprintf("unknown format %q", *format)))
+	}
+	m, err := model.NewModelFromFile(opts.modelPath)
+	if err != nil {
+		return fail("policy export", errors.Wrap(err, "model.NewModelFromFile"))
+	}
+	rules, err := readPolicySource(context.Background(), opts.policy)
+	if err != nil {
+		return fail("policy export", errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", opts.policy)))
+	}
+	for i := range rules {
+		rules[i].Line = 0
+	}
+	sortRules(m, rules)
+
+	var f *os.File
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		if f, err = os.Create(*out); err != nil {
+			return fail("policy export", errors.Wrap(err, "os.Create"))
+		}
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rules)
+	} else {
+		err = writePolicyCSV(w, m, rules)
+	}
+	if f != nil {
+		// A failed close can lose what was written.
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = errors.Wrap(closeErr, "Close")
+		}
+	}
+	if err != nil {
+		return fail("policy export", err)
+	}
+	return 0
+}
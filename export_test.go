@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+func TestWritePolicyCSV(t *testing.T) {
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []CSVRule{
+		{Ptype: "p", Rule: []string{"role:admin:0", "dom:marketing", "obj:news", "act:read", "allow"}},
+		{Ptype: "p", Rule: []string{"user:o'brien, jr", "dom:marketing", "obj:news", "act:read", "allow"}},
+		{Ptype: "p", Rule: []string{`user:"q"`, "dom:Guest", "obj:news", "act:read", "allow"}},
+		{Ptype: "g", Rule: []string{"user:o'brien, jr", "role:admin:0", "dom:marketing"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writePolicyCSV(&buf, m, rules); err != nil {
+		t.Fatal(err)
+	}
+	want := `p,role:admin:0,dom:marketing,obj:news,act:read,allow
+p,"user:o'brien, jr",dom:marketing,obj:news,act:read,allow
+
+p,"user:""q""",dom:Guest,obj:news,act:read,allow
+
+g,"user:o'brien, jr",role:admin:0,dom:marketing
+`
+	if buf.String() != want {
+		t.Errorf("writePolicyCSV wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	read, err := readPolicyCSV(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range read {
+		read[i].Line = 0
+	}
+	if fmt.Sprint(read) != fmt.Sprint(rules) {
+		t.Errorf("readPolicyCSV read %v, want %v", read, rules)
+	}
+
+	path := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := openPolicySource(DefaultModelPath, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := e.Enforce("user:o'brien, jr", "dom:marketing", "obj:news", "act:read"); err != nil || !ok {
+		t.Errorf("Enforce = %t, %v, want true", ok, err)
+	}
+}
+
+func TestSortRules(t *testing.T) {
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := readTestRules(t, `
+g3, act:read, act:all
+g, user:ian, role:admin:0, dom:marketing
+p, user:ian, dom:marketing, obj:news, act:read, deny
+p, role:admin:0, dom:marketing, obj:news, act:read, allow
+g2, obj:news, subscription:media
+p, role:admin:1, dom:Company, obj:news, act:read, allow
+p, user:ian, dom:marketing, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+`)
+	sortRules(m, rules)
+	want := []string{
+		"p, role:admin:1, dom:Company, obj:news, act:read, allow",
+		"p, role:admin:0, dom:marketing, obj:news, act:read, allow",
+		// The rules of one subject keep their order.
+		"p, user:ian, dom:marketing, obj:news, act:read, deny",
+		"p, user:ian, dom:marketing, obj:news, act:read, allow",
+		"g, user:jason, role:root:0, dom:Company",
+		"g, user:ian, role:admin:0, dom:marketing",
+		"g2, obj:news, subscription:media",
+		"g3, act:read, act:all",
+	}
+	got := []string{}
+	for _, rule := range rules {
+		got = append(got, rule.Ptype+", "+strings.Join(rule.Rule, ", "))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sortRules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExportRules(t *testing.T) {
+	ctx := context.Background()
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb, err := db.OpenSQLite(filepath.Join(t.TempDir(), "policy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules, err := ExportRules(ctx, gdb); err != nil || len(rules) != 0 {
+		t.Fatalf("ExportRules without a table = %v, %v", rules, err)
+	}
+
+	rules := readTestRules(t, `
+p, role:admin:0, dom:marketing, obj:news, act:read, allow
+g, user:ian, role:admin:0, dom:marketing
+g3, act:read, act:all
+`)
+	if _, err := MigrateRules(ctx, gdb, openTestRepository(t), m, rules, false); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := ExportRules(ctx, gdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range rules {
+		rules[i].Line = 0
+	}
+	if fmt.Sprint(exported) != fmt.Sprint(rules) {
+		t.Errorf("ExportRules = %v, want %v", exported, rules)
+	}
+}
@@ -5,6 +5,7 @@ go 1.21.6
 require (
 	github.com/casbin/casbin/v2 v2.81.0
 	github.com/casbin/gorm-adapter/v3 v3.20.0
+	github.com/glebarez/sqlite v1.7.0
 	github.com/pkg/errors v0.8.1
 	gorm.io/driver/mysql v1.4.1
 	gorm.io/gorm v1.24.5
@@ -14,7 +15,6 @@ require (
 	github.com/casbin/govaluate v1.1.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/glebarez/go-sqlite v1.20.3 // indirect
-	github.com/glebarez/sqlite v1.7.0 // indirect
 	github.com/go-sql-driver/mysql v1.6.0 // indirect
 	github.com/golang-sql/civil v0.0.0-20220223132316-b832511892a9 // indirect
 	github.com/golang-sql/sqlexp v0.1.0 // indirect
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// ObjectGroupType is the grouping policy that bundles objects, so that
+	// "g2, obj:news, subscription:exhibition_guest" lets a grant of the bundle
+	// cover obj:news. Bundles may contain other bundles.
+	ObjectGroupType = "g2"
+	// ActionGroupType is the grouping policy that nests actions, so that
+	// "g3, act:create_limited, act:create" lets a grant of act:create cover
+	// act:create_limited.
+	ActionGroupType = "g3"
+
+	BundlePrefix = "subscription:"
+)
+
+// impliesObject reports whether a grant on granted covers obj.
+func impliesObject(e *Enforcer, granted string, obj string) bool {
+	return implies(e, ObjectGroupType, granted, obj)
+}
+
+// impliesAction reports whether a grant of granted covers act.
+func impliesAction(e *Enforcer, granted string, act string) bool {
+	return implies(e, ActionGroupType, granted, act)
+}
+
+func implies(e *Enforcer, ptype string, granted string, value string) bool {
+	if granted == value {
+		return true
+	}
+	rm := e.GetNamedRoleManager(ptype)
+	if rm == nil {
+		return false
+	}
+	ok, err := rm.HasLink(value, granted)
+	return err == nil && ok
+}
+
+// impliedObjects returns the matrix objects a grant on granted covers, in
+// matrix order.
+func impliedObjects(e *Enforcer, granted string) []string {
+	var objects []string
+	for _, obj := range matrixObjects(e) {
+		if impliesObject(e, granted, obj) {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// impliedActions returns the vocabulary actions a grant of granted covers, in
+// vocabulary order.
+func impliedActions(e *Enforcer, granted string) []string {
+	var actions []string
+	for _, act := range vocabulary.Actions() {
+		if impliesAction(e, granted, act) {
+			actions = append(actions, act)
+		}
+	}
+	return actions
+}
+
+// bundles returns every bundle named by ObjectGroupType rules, in policy
+// order.
+func bundles(e *Enforcer) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, g := range e.GetNamedGroupingPolicy(ObjectGroupType) {
+		for _, name := range g[:2] {
+			if strings.HasPrefix(name, BundlePrefix) && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// matrixObjects returns the vocabulary objects followed by the bundles, the
+// rows of every permission matrix.
+func matrixObjects(e *Enforcer) []string {
+	return append(vocabulary.Objects(), bundles(e)...)
+}
+
+// bundleMembers returns the names of the vocabulary objects bundle covers.
+func bundleMembers(e *Enforcer, bundle string) []string {
+	var members []string
+	for _, obj := range vocabulary.Objects() {
+		if impliesObject(e, bundle, obj) {
+			members = append(members, permissionName(obj))
+		}
+	}
+	return members
+}
+
+// knownObject accepts the objects of the vocabulary and the bundles.
+func knownObject(e *Enforcer, obj string) bool {
+	if vocabulary.HasObject(obj) {
+		return true
+	}
+	for _, bundle := range bundles(e) {
+		if bundle == obj {
+			return true
+		}
+	}
+	return false
+}
+
+// knownAction accepts the actions of the vocabulary and the groups of them
+// defined by ActionGroupType rules, such as act:all.
+func knownAction(e *Enforcer, act string) bool {
+	if vocabulary.HasAction(act) {
+		return true
+	}
+	return len(e.GetFilteredNamedGroupingPolicy(ActionGroupType, 1, act)) > 0
+}
+
+// permissionName is the Permission.Name of obj. Objects lose their "obj:"
+// prefix; bundles keep theirs.
+func permissionName(obj string) string {
+	return strings.TrimPrefix(obj, ObjPrefix)
+}
+
+// permissionObject is the inverse of permissionName.
+func permissionObject(name string) string {
+	if strings.HasPrefix(name, BundlePrefix) {
+		return name
+	}
+	return ObjPrefix + name
+}
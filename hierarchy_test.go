@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// limitedGroupRules add act:all_limited to groupRules, as policy_my.csv does.
+const limitedGroupRules = `
+g3, act:read, act:all_limited
+g3, act:create_limited, act:all_limited
+g3, act:update_limited, act:all_limited
+g3, act:delete_limited, act:all_limited
+`
+
+func TestActionHierarchy(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:editor:1, dom:Company, obj:news, act:all, allow
+p, role:writer:2, dom:Company, obj:news, act:all_limited, allow
+p, role:author:3, dom:Company, obj:news, act:create, allow
+`+limitedGroupRules)
+
+	tests := []struct {
+		granted string
+		actions string
+	}{
+		{"act:all", "act:read act:create act:update act:delete act:create_limited act:update_limited act:delete_limited"},
+		{"act:all_limited", "act:read act:create_limited act:update_limited act:delete_limited"},
+		{"act:create", "act:create act:create_limited"},
+		{"act:create_limited", "act:create_limited"},
+		{"act:publish", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(impliedActions(e, tt.granted), " "); got != tt.actions {
+			t.Errorf("impliedActions(%s) = %s, want %s", tt.granted, got, tt.actions)
+		}
+	}
+
+	roles := map[string]string{
+		"act:all":         "role:editor:1",
+		"act:all_limited": "role:writer:2",
+		"act:create":      "role:author:3",
+	}
+	for granted, role := range roles {
+		for _, act := range vocabulary.Actions() {
+			want := impliesAction(e, granted, act)
+			allowed, err := e.Enforce(role, "dom:Company", "obj:news", act)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != want {
+				t.Errorf("Enforce(%s, %s) = %v, want %v", role, act, allowed, want)
+			}
+			if got := matrixStatus(t, e, role, "dom:Company", "obj:news", act); got != want {
+				t.Errorf("matrix of %s has %s %v, want %v", role, act, got, want)
+			}
+		}
+	}
+
+	for act, want := range map[string]bool{
+		"act:read":        true,
+		"act:all":         true,
+		"act:all_limited": true,
+		"act:publish":     false,
+		"obj:news":        false,
+	} {
+		if got := knownAction(e, act); got != want {
+			t.Errorf("knownAction(%s) = %v, want %v", act, got, want)
+		}
+	}
+}
+
+func TestObjectBundles(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:guest:1, dom:Company, obj:news_tag, act:read, deny
+p, role:guest:1, dom:Company, subscription:exhibition_guest, act:read, allow
+p, role:host:1, dom:Company, subscription:all, act:update, allow
+g2, obj:exhibition, subscription:exhibition_guest
+g2, obj:news, subscription:exhibition_guest
+g2, obj:news_tag, subscription:exhibition_guest
+g2, subscription:exhibition_guest, subscription:all
+g2, obj:period, subscription:all
+`)
+
+	if got := strings.Join(bundles(e), " "); got != "subscription:exhibition_guest subscription:all" {
+		t.Errorf("bundles = %s", got)
+	}
+	tests := []struct {
+		bundle  string
+		members string
+	}{
+		{"subscription:exhibition_guest", "exhibition news_tag news"},
+		{"subscription:all", "period exhibition news_tag news"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(bundleMembers(e, tt.bundle), " "); got != tt.members {
+			t.Errorf("bundleMembers(%s) = %s, want %s", tt.bundle, got, tt.members)
+		}
+	}
+
+	checks := []struct {
+		sub, obj, act string
+		want          bool
+	}{
+		{"role:guest:1", "obj:news", "act:read", true},
+		{"role:guest:1", "obj:exhibition", "act:read", true},
+		{"role:guest:1", "obj:news_tag", "act:read", false},
+		{"role:guest:1", "obj:period", "act:read", false},
+		{"role:guest:1", "obj:news", "act:update", false},
+		{"role:guest:1", "subscription:exhibition_guest", "act:read", true},
+		{"role:host:1", "obj:period", "act:update", true},
+		{"role:host:1", "obj:news", "act:update", true},
+		{"role:host:1", "subscription:exhibition_guest", "act:update", true},
+		{"role:host:1", "obj:account", "act:update", false},
+	}
+	for _, tt := range checks {
+		allowed, err := e.Enforce(tt.sub, "dom:Company", tt.obj, tt.act)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.want {
+			t.Errorf("Enforce(%s, %s, %s) = %v, want %v", tt.sub, tt.obj, tt.act, allowed, tt.want)
+		}
+		if got := matrixStatus(t, e, tt.sub, "dom:Company", tt.obj, tt.act); got != tt.want {
+			t.Errorf("matrix of %s has %s/%s %v, want %v", tt.sub, tt.obj, tt.act, got, tt.want)
+		}
+	}
+
+	for obj, want := range map[string]bool{
+		"obj:news":                      true,
+		"subscription:all":              true,
+		"subscription:exhibition_guest": true,
+		"subscription:unknown":          false,
+		"obj:invoice":                   false,
+	} {
+		if got := knownObject(e, obj); got != want {
+			t.Errorf("knownObject(%s) = %v, want %v", obj, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+// levelInheritance lets a role inherit every grant of the roles with a
+// greater level number in the same domain, so role:admin:1 gets whatever
+// role:admin_member:2 has in dom:Company.
+var levelInheritance bool
+
+// linkLevels links, in e's role manager, every role of each domain to the
+// roles with a greater level number there, so that e decides with level
+// inheritance. The links are not g rules and are never saved.
+func linkLevels(e *Enforcer) error {
+	rm := e.GetRoleManager()
+	doms := make(map[string]bool)
+	for _, p := range e.GetPolicy() {
+		doms[p[1]] = true
+	}
+	for _, g := range e.GetNamedGroupingPolicy("g") {
+		doms[g[2]] = true
+	}
+	for _, dom := range sortedKeys(doms) {
+		roles := domainRoles(e, dom)
+		for _, role := range roles {
+			_, level, err := parseRole(role)
+			if err != nil {
+				continue
+			}
+			for _, other := range roles {
+				if _, otherLevel, err := parseRole(other); err != nil || otherLevel <= level {
+					continue
+				}
+				if err := rm.AddLink(role, other, dom); err != nil {
+					return errors.Wrap(err, fmt.Sprintf("AddLink(%s, %s, %s)", role, other, dom))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sortByLevel orders the p rules of roles by level number after the rules of
+// users, keeping the subject hierarchy order otherwise. Under level
+// inheritance a role's own rules then come before the ones it inherits, so
+// its deny rules win over inherited grants.
+func sortByLevel(m model.Model) {
+	rank := func(rule []string) int {
+		if _, level, err := parseRole(rule[0]); err == nil {
+			return level + 1
+		}
+		return 0
+	}
+	for _, assertion := range m["p"] {
+		sort.SliceStable(assertion.Policy, func(i, j int) bool {
+			return rank(assertion.Policy[i]) < rank(assertion.Policy[j])
+		})
+		for i, rule := range assertion.Policy {
+			assertion.PolicyMap[strings.Join(rule, ",")] = i
+		}
+	}
+}
+
+// inheritedRoles returns the roles of dom that roles inherit from under level
+// inheritance, ordered by level. Roles already in roles are left out.
+func inheritedRoles(e *Enforcer, dom string, roles []string) []string {
+	held := make(map[string]bool)
+	minLevel := -1
+	for _, role := range roles {
+		_, level, err := parseRole(role)
+		if err != nil {
+			continue
+		}
+		held[role] = true
+		if minLevel < 0 || level < minLevel {
+			minLevel = level
+		}
+	}
+	if minLevel < 0 {
+		return nil
+	}
+
+	var inherited []string
+	levels := make(map[string]int)
+	for _, role := range domainRoles(e, dom) {
+		_, level, err := parseRole(role)
+		if err != nil || held[role] || level <= minLevel {
+			continue
+		}
+		inherited = append(inherited, role)
+		levels[role] = level
+	}
+	sort.SliceStable(inherited, func(i, j int) bool {
+		return levels[inherited[i]] < levels[inherited[j]]
+	})
+	return inherited
+}
+
+// domainRoles returns every role granted something or held by someone in dom.
+func domainRoles(e *Enforcer, dom string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	add := func(role string) {
+		if strings.HasPrefix(role, RolePrefix) && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	for _, p := range e.GetFilteredPolicy(1, dom) {
+		add(p[0])
+	}
+	for _, g := range e.GetFilteredNamedGroupingPolicy("g", 2, dom) {
+		add(g[1])
+	}
+	return roles
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const levelRules = `
+p, role:admin:1, dom:Company, obj:news, act:update, allow
+p, role:admin:1, dom:Company, obj:period, act:read, deny
+p, role:admin_member:2, dom:Company, obj:news, act:read, allow
+p, role:admin_member:2, dom:Company, obj:period, act:read, allow
+p, role:guest:3, dom:Company, obj:exhibition, act:read, allow
+p, role:admin_member:2, dom:marketing, obj:account, act:read, allow
+g, user:sonnie, role:admin:1, dom:Company
+g, user:harry, role:admin_member:2, dom:Company
+`
+
+func TestLevelInheritance(t *testing.T) {
+	tests := []struct {
+		sub, dom, obj, act string
+		inherit, plain     bool
+	}{
+		{"role:admin:1", "dom:Company", "obj:news", "act:read", true, false},
+		{"role:admin:1", "dom:Company", "obj:exhibition", "act:read", true, false},
+		{"role:admin:1", "dom:Company", "obj:news", "act:update", true, true},
+		{"role:admin:1", "dom:Company", "obj:period", "act:read", false, false},
+		{"role:admin:1", "dom:Company", "obj:account", "act:read", false, false},
+		{"role:admin_member:2", "dom:Company", "obj:news", "act:update", false, false},
+		{"role:admin_member:2", "dom:Company", "obj:exhibition", "act:read", true, false},
+		{"role:guest:3", "dom:Company", "obj:news", "act:read", false, false},
+		{"user:sonnie", "dom:Company", "obj:news", "act:read", true, false},
+		{"user:sonnie", "dom:Company", "obj:period", "act:read", false, false},
+		{"user:harry", "dom:Company", "obj:period", "act:read", true, true},
+	}
+	defer func() { levelInheritance = false }()
+	for _, inherit := range []bool{true, false} {
+		levelInheritance = inherit
+		e := openTestPolicy(t, levelRules)
+		for _, tt := range tests {
+			want := tt.plain
+			if inherit {
+				want = tt.inherit
+			}
+			allowed, err := e.Enforce(tt.sub, tt.dom, tt.obj, tt.act)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != want {
+				t.Errorf("inheritance %v: Enforce(%s, %s, %s, %s) = %v, want %v", inherit, tt.sub, tt.dom, tt.obj, tt.act, allowed, want)
+			}
+			if got := matrixStatus(t, e, tt.sub, tt.dom, tt.obj, tt.act); got != want {
+				t.Errorf("inheritance %v: matrix of %s has %s/%s %v, want %v", inherit, tt.sub, tt.obj, tt.act, got, want)
+			}
+		}
+	}
+}
+
+func TestInheritedMatrixEntries(t *testing.T) {
+	levelInheritance = true
+	defer func() { levelInheritance = false }()
+	e := openTestPolicy(t, levelRules)
+
+	permissions, err := getRolePermissionsFromPolicy(context.Background(), e, "role:admin:1", "dom:Company")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited := []string{}
+	for _, permission := range permissions {
+		for _, action := range permission.Actions {
+			if action.Inherited {
+				if !action.Status {
+					t.Errorf("%s/%s is inherited but denied", permission.Name, action.Name)
+				}
+				inherited = append(inherited, permission.Name+"/"+action.Name)
+			}
+		}
+	}
+	if got := strings.Join(inherited, " "); got != "exhibition/read news/read" {
+		t.Errorf("inherited entries = %s", got)
+	}
+
+	// The links between levels stay in memory and follow policy changes.
+	if got := len(e.GetNamedGroupingPolicy("g")); got != 2 {
+		t.Errorf("%d g rules, want 2", got)
+	}
+	delta := policyDelta{ptype: "p", removed: [][]string{{"role:guest:3", "dom:Company", "obj:exhibition", "act:read", "allow"}}}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := e.GetRoleManager().HasLink("role:admin:1", "role:guest:3", "dom:Company"); ok {
+		t.Error("role:admin:1 still inherits from a role that no longer exists")
+	}
+	if ok, _ := e.GetRoleManager().HasLink("role:admin:1", "role:admin_member:2", "dom:Company"); !ok {
+		t.Error("role:admin:1 lost its link to role:admin_member:2")
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+type LintCheck string
+
+const (
+	LintInvalidRule   LintCheck = "invalid_rule"
+	LintUnknownObject LintCheck = "unknown_object"
+	LintUnknownAction LintCheck = "unknown_action"
+	LintDomainCase    LintCheck = "domain_case"
+	LintMalformedRole LintCheck = "malformed_role"
+	LintUnusedRole    LintCheck = "role_without_members"
+	LintOrphanMember  LintCheck = "role_without_rules"
+	LintDuplicateRule LintCheck = "duplicate_rule"
+	LintRedundantRule LintCheck = "redundant_rule"
+)
+
+// LintIssue is one problem found in a policy. Fixable issues are removed by
+// FixPolicyCSV. Warnings point at rules that change no decision.
+type LintIssue struct {
+	CSVRule
+	Check   LintCheck `json:"check"`
+	Message string    `json:"message"`
+	Fixable bool      `json:"fixable"`
+	Warning bool      `json:"warning,omitempty"`
+}
+
+// LintPolicy checks rules for mistakes that casbin accepts but that grant
+// nothing or something other than intended, in rule order.
+func LintPolicy(m model.Model, rules []CSVRule) []LintIssue {
+	l := newPolicyLinter(m, rules)
+	for i, rule := range rules {
+		if reason := validateRule(m, rule); reason != "" {
+			l.report(rule, LintInvalidRule, false, reason)
+			continue
+		}
+		if l.duplicate(i) {
+			l.report(rule, LintDuplicateRule, true, "same as an earlier rule")
+			continue
+		}
+		switch rule.Ptype {
+		case "p":
+			l.checkRole(rule, rule.Rule[0])
+			l.checkDomain(rule, rule.Rule[1])
+			l.checkObject(rule, rule.Rule[2])
+			l.checkAction(rule, rule.Rule[3])
+			l.checkMembers(rule)
+			l.checkRedundant(i)
+		case "g":
+			l.checkRole(rule, rule.Rule[0])
+			l.checkRole(rule, rule.Rule[1])
+			l.checkDomain(rule, rule.Rule[2])
+			l.checkGrants(rule)
+		case ObjectGroupType:
+			l.checkObject(rule, rule.Rule[0])
+			if !strings.HasPrefix(rule.Rule[1], BundlePrefix) {
+				l.report(rule, LintUnknownObject, false, fmt.Sprintf("%s groups objects but is not a %s bundle", rule.Rule[1], BundlePrefix))
+			}
+		case ActionGroupType:
+			l.checkAction(rule, rule.Rule[0])
+		}
+	}
+	return l.issues
+}
+
+type policyLinter struct {
+	rules  []CSVRule
+	issues []LintIssue
+
+	bundles      map[string]bool
+	actionGroups map[string]bool
+	// objectLinks and actionLinks map a value to the groups it belongs to,
+	// directly or through other groups.
+	objectLinks map[string]map[string]bool
+	actionLinks map[string]map[string]bool
+	// granted are the "role, dom" pairs with p rules, directly or through g
+	// rules between roles.
+	granted map[string]bool
+	members map[string]bool
+	// domains maps each lower-cased domain to its spellings and their counts.
+	domains map[string]map[string]int
+}
+
+func newPolicyLinter(m model.Model, rules []CSVRule) *policyLinter {
+	l := &policyLinter{
+		rules:        rules,
+		bundles:      make(map[string]bool),
+		actionGroups: make(map[string]bool),
+		granted:      make(map[string]bool),
+		members:      make(map[string]bool),
+		domains:      make(map[string]map[string]int),
+	}
+	var objectEdges, actionEdges, roleEdges [][]string
+	for _, rule := range rules {
+		if validateRule(m, rule) != "" {
+			continue
+		}
+		switch rule.Ptype {
+		case "p":
+			l.granted[rule.Rule[0]+", "+rule.Rule[1]] = true
+			l.countDomain(rule.Rule[1])
+		case "g":
+			roleEdges = append(roleEdges, rule.Rule)
+			l.members[rule.Rule[1]+", "+rule.Rule[2]] = true
+			l.countDomain(rule.Rule[2])
+		case ObjectGroupType:
+			objectEdges = append(objectEdges, rule.Rule)
+			for _, name := range rule.Rule {
+				if strings.HasPrefix(name, BundlePrefix) {
+					l.bundles[name] = true
+				}
+			}
+		case ActionGroupType:
+			actionEdges = append(actionEdges, rule.Rule)
+			l.actionGroups[rule.Rule[1]] = true
+		}
+	}
+	l.objectLinks = groupClosure(objectEdges)
+	l.actionLinks = groupClosure(actionEdges)
+
+	// A role that is a member of a granted role is granted too.
+	for changed := true; changed; {
+		changed = false
+		for _, g := range roleEdges {
+			from, to := g[0]+", "+g[2], g[1]+", "+g[2]
+			if l.granted[to] && !l.granted[from] {
+				l.granted[from] = true
+				changed = true
+			}
+		}
+	}
+	return l
+}
+
+// groupClosure maps every value of edges to all the groups it belongs to.
+func groupClosure(edges [][]string) map[string]map[string]bool {
+	closure := make(map[string]map[string]bool)
+	for _, edge := range edges {
+		if closure[edge[0]] == nil {
+			closure[edge[0]] = make(map[string]bool)
+		}
+		closure[edge[0]][edge[1]] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, groups := range closure {
+			for group := range groups {
+				for parent := range closure[group] {
+					if !groups[parent] {
+						groups[parent] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return closure
+}
+
+func (l *policyLinter) report(rule CSVRule, check LintCheck, fixable bool, message string) {
+	l.issues = append(l.issues, LintIssue{CSVRule: rule, Check: check, Message: message, Fixable: fixable})
+}
+
+func (l *policyLinter) warn(rule CSVRule, check LintCheck, message string) {
+	l.issues = append(l.issues, LintIssue{CSVRule: rule, Check: check, Message: message, Warning: true})
+}
+
+func (l *policyLinter) countDomain(dom string) {
+	key := strings.ToLower(dom)
+	if l.domains[key] == nil {
+		l.domains[key] = make(map[string]int)
+	}
+	l.domains[key][dom]++
+}
+
+func (l *policyLinter) duplicate(i int) bool {
+	for _, earlier := range l.rules[:i] {
+		if earlier.Ptype == l.rules[i].Ptype && strings.Join(earlier.Rule, ", ") == strings.Join(l.rules[i].Rule, ", ") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkObject flags objects that are neither in the registry nor bundles,
+// including registry names written without their "obj:" prefix.
+func (l *policyLinter) checkObject(rule CSVRule, obj string) {
+	switch {
+	case vocabulary.HasObject(obj) || l.bundles[obj]:
+	case !hasNamePrefix(obj, []string{ObjPrefix, BundlePrefix}):
+		l.report(rule, LintUnknownObject, false, fmt.Sprintf("%s has no %s or %s prefix", obj, ObjPrefix, BundlePrefix))
+	default:
+		l.report(rule, LintUnknownObject, false, fmt.Sprintf("%s is neither in the registry nor a bundle", obj))
+	}
+}
+
+// checkAction flags actions that are neither in the registry nor action
+// groups, including those without their "act:" prefix.
+func (l *policyLinter) checkAction(rule CSVRule, act string) {
+	switch {
+	case vocabulary.HasAction(act) || l.actionGroups[act]:
+	case !hasNamePrefix(act, []string{ActPrefix}):
+		l.report(rule, LintUnknownAction, false, fmt.Sprintf("%s has no %s prefix", act, ActPrefix))
+	default:
+		l.report(rule, LintUnknownAction, false, fmt.Sprintf("%s is neither in the registry nor an action group", act))
+	}
+}
+
+// checkDomain flags the less used spelling of a domain that is also written
+// with different case, such as dom:company next to dom:Company.
+func (l *policyLinter) checkDomain(rule CSVRule, dom string) {
+	spellings := l.domains[strings.ToLower(dom)]
+	for spelling, count := range spellings {
+		if spelling != dom && (count > spellings[dom] || count == spellings[dom] && spelling < dom) {
+			l.report(rule, LintDomainCase, false, fmt.Sprintf("%s is also written %s", dom, spelling))
+			return
+		}
+	}
+}
+
+// checkRole flags role subjects that parseRole rejects. Users are left alone.
+func (l *policyLinter) checkRole(rule CSVRule, subject string) {
+	if strings.HasPrefix(subject, UserPrefix) {
+		return
+	}
+	if _, level, err := parseRole(subject); err != nil {
+		l.report(rule, LintMalformedRole, false, err.Error())
+	} else if level < 0 {
+		l.report(rule, LintMalformedRole, false, fmt.Sprintf("%q has a negative level", subject))
+	}
+}
+
+// checkMembers flags p rules of roles that no g rule gives to anyone in
+// their domain. Such roles may be waiting for their first member, so they
+// are left in place.
+func (l *policyLinter) checkMembers(rule CSVRule) {
+	role, dom := rule.Rule[0], rule.Rule[1]
+	if !strings.HasPrefix(role, RolePrefix) || l.members[role+", "+dom] {
+		return
+	}
+	l.warn(rule, LintUnusedRole, fmt.Sprintf("%s has no members in %s", role, dom))
+}
+
+// checkGrants flags g rules to roles that grant nothing in their domain. The
+// root role is granted by the matcher rather than by p rules, and with level
+// inheritance a role is granted by any higher-numbered role of its domain.
+func (l *policyLinter) checkGrants(rule CSVRule) {
+	role, dom := rule.Rule[1], rule.Rule[2]
+	if role == string(RootRole) && dom == string(CompanyDom) || l.granted[role+", "+dom] {
+		return
+	}
+	if _, level, err := parseRole(role); err == nil && levelInheritance {
+		for _, other := range l.rules {
+			if other.Ptype != "p" || len(other.Rule) < 2 || other.Rule[1] != dom {
+				continue
+			}
+			if _, otherLevel, err := parseRole(other.Rule[0]); err == nil && otherLevel > level {
+				return
+			}
+		}
+	}
+	l.report(rule, LintOrphanMember, true, fmt.Sprintf("%s has no rules in %s", role, dom))
+}
+
+// checkRedundant flags a p rule covered by a broader rule of the same
+// subject, domain and effect, when no rule of the other effect overlaps it.
+// Removing it changes no decision.
+func (l *policyLinter) checkRedundant(i int) {
+	rule := l.rules[i]
+	var broader []string
+	for j, other := range l.rules {
+		if j == i || other.Ptype != "p" || len(other.Rule) != len(rule.Rule) ||
+			other.Rule[0] != rule.Rule[0] || other.Rule[1] != rule.Rule[1] {
+			continue
+		}
+		covers := l.covers(other.Rule, rule.Rule)
+		if other.Rule[4] != rule.Rule[4] && (covers || l.covers(rule.Rule, other.Rule)) {
+			return
+		}
+		if other.Rule[4] == rule.Rule[4] && covers && !l.covers(rule.Rule, other.Rule) {
+			broader = append(broader, other.Rule[2]+", "+other.Rule[3])
+		}
+	}
+	if len(broader) > 0 {
+		sort.Strings(broader)
+		l.warn(rule, LintRedundantRule, fmt.Sprintf("covered by %s", broader[0]))
+	}
+}
+
+// covers reports whether the object and action of p rule a include those of
+// p rule b.
+func (l *policyLinter) covers(a []string, b []string) bool {
+	return (a[2] == b[2] || l.objectLinks[b[2]][a[2]]) && (a[3] == b[3] || l.actionLinks[b[3]][a[3]])
+}
+
+// fixRules returns rules without those of the fixable issues. A rule is
+// dropped only when both its position and its values match the issue, so
+// issues found in another version of the policy remove nothing.
+func fixRules(rules []CSVRule, issues []LintIssue) []CSVRule {
+	remove := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.Fixable {
+			remove[fixKey(issue.CSVRule)] = true
+		}
+	}
+	var fixed []CSVRule
+	for _, rule := range rules {
+		if !remove[fixKey(rule)] {
+			fixed = append(fixed, rule)
+		}
+	}
+	return fixed
+}
+
+// fixKey identifies rule by its line and values.
+func fixKey(rule CSVRule) string {
+	return fmt.Sprintf("%d %s, %s", rule.Line, rule.Ptype, strings.Join(rule.Rule, ", "))
+}
+
+// FixPolicyCSV rewrites the policy CSV file at path from its parsed rules,
+// without the rules of the fixable issues, and returns how many rules it
+// removed. Comments are not kept.
+func FixPolicyCSV(path string, m model.Model, issues []LintIssue) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "os.ReadFile")
+	}
+	rules, err := readPolicyCSV(bytes.NewReader(data))
+	if err != nil {
+		return 0, errors.Wrap(err, "readPolicyCSV")
+	}
+	fixed := fixRules(rules, issues)
+	if len(fixed) == len(rules) {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	if err := writePolicyCSV(&buf, m, fixed); err != nil {
+		return 0, errors.Wrap(err, "writePolicyCSV")
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return 0, errors.Wrap(err, "os.WriteFile")
+	}
+	return len(rules) - len(fixed), nil
+}
+
+// runLint implements "lint". It returns 1 when issues other than warnings
+// remain, and 2 on errors.
+func runLint(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	opts.register(fs)
+	fix := fs.Bool("fix", false, "remove duplicate rules and g rules to roles without rules from the CSV file")
+	reason := fs.String("reason", "", "reason recorded in the audit trail for -fix")
+	format := fs.String("format", "text", "output format, text or json")
+	fs.Parse(args)
+
+	m, err := model.NewModelFromFile(opts.modelPath)
+	if err != nil {
+		return fail("lint", errors.Wrap(err, "model.NewModelFromFile"))
+	}
+	if err := opts.loadVocabulary(); err != nil {
+		return fail("lint", err)
+	}
+	rules, err := readPolicySource(context.Background(), opts.policy)
+	if err != nil {
+		return fail("lint", errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", opts.policy)))
+	}
+	issues := LintPolicy(m, rules)
+
+	if *fix {
+		gdb, err := policyDB(opts.policy)
+		if err != nil {
+			return fail("lint", err)
+		}
+		if gdb != nil {
+			return fail("lint", errors.Wrap(ErrInvalidRequest, "-fix only edits CSV files"))
+		}
+		ctx := WithAuditReason(context.Background(), *reason)
+		fixed := fixRules(rules, issues)
+		repo, err := opts.openRepository(ctx)
+		if err != nil {
+			return fail("lint", err)
+		}
+		if err := recordRuleRewrite(ctx, repo, m, LintActor, rules, fixed); err != nil {
+			return fail("lint", err)
+		}
+		removed, err := FixPolicyCSV(opts.policy, m, issues)
+		if err != nil {
+			return fail("lint", errors.Wrap(err, fmt.Sprintf("FixPolicyCSV(%s)", opts.policy)))
+		}
+		if rules, err = readPolicySource(context.Background(), opts.policy); err != nil {
+			return fail("lint", errors.Wrap(err, fmt.Sprintf("readPolicySource(%s)", opts.policy)))
+		}
+		issues = LintPolicy(m, rules)
+		if *format != "json" {
+			fmt.Printf("removed %d rules\n", removed)
+		}
+	}
+
+	if *format == "json" {
+		if issues == nil {
+			issues = []LintIssue{}
+		}
+		if err := printJSON(issues); err != nil {
+			return fail("lint", err)
+		}
+	} else {
+		writeLintText(os.Stdout, opts.policy, issues)
+	}
+	for _, issue := range issues {
+		if !issue.Warning {
+			return 1
+		}
+	}
+	return 0
+}
+
+func writeLintText(w io.Writer, path string, issues []LintIssue) {
+	for _, issue := range issues {
+		kind := "error"
+		if issue.Warning {
+			kind = "warning"
+		}
+		fix := ""
+		if issue.Fixable {
+			fix = " (fixable)"
+		}
+		fmt.Fprintf(w, "%s:%d: %s %s: %s, %s: %s%s\n", path, issue.Line, kind, issue.Check,
+			issue.Ptype, strings.Join(issue.Rule, ", "), issue.Message, fix)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+func lintTestPolicy(t *testing.T, policy string) []LintIssue {
+	t.Helper()
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := readPolicyCSV(strings.NewReader(strings.TrimSpace(policy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return LintPolicy(m, rules)
+}
+
+// issueKeys lists issues as "line check".
+func issueKeys(issues []LintIssue) []string {
+	keys := []string{}
+	for _, issue := range issues {
+		keys = append(keys, fmt.Sprintf("%d %s", issue.Line, issue.Check))
+	}
+	return keys
+}
+
+func TestLintPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   []string
+	}{
+		{
+			name: "clean",
+			policy: `
+p, role:editor:1, dom:Company, obj:news, act:read, allow
+g, user:alice, role:editor:1, dom:Company`,
+			want: []string{},
+		},
+		{
+			name: "unprefixed object and action",
+			policy: `
+p, role:x:1, dom:Company, news, read, allow
+g, user:alice, role:x:1, dom:Company`,
+			want: []string{"1 unknown_object", "1 unknown_action"},
+		},
+		{
+			name: "unknown object and action",
+			policy: `
+p, role:x:1, dom:Company, obj:new, act:publish, allow
+g, user:alice, role:x:1, dom:Company`,
+			want: []string{"1 unknown_object", "1 unknown_action"},
+		},
+		{
+			name: "bundles and action groups are known",
+			policy: `
+p, role:x:1, dom:Company, subscription:media, act:all, allow
+g, user:alice, role:x:1, dom:Company
+g2, obj:news, subscription:media
+g3, act:read, act:all`,
+			want: []string{},
+		},
+		{
+			name: "invalid rules",
+			policy: `
+p, role:x:1, dom:Company, obj:news, act:read, maybe
+p, role:x:1, dom:Company, obj:news
+g4, a, b`,
+			want: []string{"1 invalid_rule", "2 invalid_rule", "3 invalid_rule"},
+		},
+		{
+			name: "domain case",
+			policy: `
+p, role:x:1, dom:Company, obj:news, act:read, allow
+p, role:x:1, dom:Company, obj:news, act:create, allow
+g, user:alice, role:x:1, dom:company`,
+			want: []string{"1 role_without_members", "2 role_without_members", "3 domain_case", "3 role_without_rules"},
+		},
+		{
+			name: "malformed role and duplicate",
+			policy: `
+p, role:x, dom:Company, obj:news, act:read, allow
+p, role:y:1, dom:Company, obj:news, act:read, allow
+p, role:y:1, dom:Company, obj:news, act:read, allow
+g, user:alice, role:y:1, dom:Company`,
+			want: []string{"1 malformed_role", "1 role_without_members", "3 duplicate_rule"},
+		},
+		{
+			name: "redundant rule",
+			policy: `
+p, role:x:1, dom:Company, obj:news, act:all, allow
+p, role:x:1, dom:Company, obj:news, act:read, allow
+g, user:alice, role:x:1, dom:Company
+g3, act:read, act:all`,
+			want: []string{"2 redundant_rule"},
+		},
+		{
+			name: "deny keeps the covered rule",
+			policy: `
+p, role:x:1, dom:Company, obj:news, act:all, allow
+p, role:x:1, dom:Company, obj:news, act:read, deny
+g, user:alice, role:x:1, dom:Company
+g3, act:read, act:all`,
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := issueKeys(lintTestPolicy(t, tt.policy))
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("issues = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixPolicyCSV(t *testing.T) {
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := `# duplicates and members of roles without rules are removed
+p, role:x:1, dom:Company, obj:news, act:read, allow
+p, role:x:1, dom:Company, obj:news, act:read, allow
+
+g, user:alice, role:x:1, dom:Company
+g, user:bob, role:ghost:2, dom:Company
+p, role:x:1, dom:Company, news, read, allow
+`
+	path := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(path, []byte(policy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Issues of another version of the file match no rule of this one.
+	stale := lintTestPolicy(t, "p, role:y:1, dom:Company, obj:news, act:read, allow\n"+policy)
+	if removed, err := FixPolicyCSV(path, m, stale); err != nil || removed != 0 {
+		t.Errorf("FixPolicyCSV with stale issues = %d, %v, want 0", removed, err)
+	}
+
+	removed, err := FixPolicyCSV(path, m, lintTestPolicy(t, policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d rules, want 2", removed)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `p,role:x:1,dom:Company,obj:news,act:read,allow
+
+g,user:alice,role:x:1,dom:Company
+
+p,role:x:1,dom:Company,news,read,allow
+`
+	if string(b) != want {
+		t.Errorf("fixed policy:\n%s\nwant:\n%s", b, want)
+	}
+	// Unknown values are reported but never removed.
+	if got := issueKeys(lintTestPolicy(t, string(b))); strings.Join(got, "; ") != "5 unknown_object; 5 unknown_action" {
+		t.Errorf("issues after fix = %q", got)
+	}
+}
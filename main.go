@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"strings"
 
-	"github.com/casbin/casbin/v2"
-	"github.com/casbin/casbin/v2/constant"
-	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"casbin-playground/account"
+	"casbin-playground/registry"
+
 	"github.com/pkg/errors"
 )
 
@@ -43,6 +46,7 @@ type DivisionRoleName string
 
 const (
 	DivisionRoleNameRoot      DivisionRoleName = "root"
+	DivisionRoleNameAdmin     DivisionRoleName = "admin"
 	DivisionRoleNameOrganiser DivisionRoleName = "organiser"
 )
 
@@ -54,63 +58,17 @@ type DivisionRole struct {
 }
 
 type Permission struct {
-	Name    string   `json:"name"`
+	Name string `json:"name"`
+	// Members are the objects covered by a "subscription:" bundle.
+	Members []string `json:"members,omitempty"`
 	Actions []Action `json:"actions"`
 }
 
 type Action struct {
 	Name   string `json:"name"`
 	Status bool   `json:"status"`
-}
-
-func getAllObjects() []string {
-	return []string{
-		"obj:account",
-		"obj:location",
-		"obj:organiser",
-		"obj:period",
-		"obj:exhibition",
-		"obj:news_tag",
-		"obj:news",
-		"obj:request_form",
-	}
-}
-
-func getAllTrimmedObjects() []string {
-	return []string{
-		"account",
-		"location",
-		"organiser",
-		"period",
-		"exhibition",
-		"news_tag",
-		"news",
-		"request_form",
-	}
-}
-
-func getAllActions() []string {
-	return []string{
-		"act:read",
-		"act:create",
-		"act:update",
-		"act:delete",
-		"act:create_limited",
-		"act:update_limited",
-		"act:delete_limited",
-	}
-}
-
-func getAllTrimmedActions() []string {
-	return []string{
-		"read",
-		"create",
-		"update",
-		"delete",
-		"create_limited",
-		"update_limited",
-		"delete_limited",
-	}
+	// Inherited marks a status granted through level inheritance only.
+	Inherited bool `json:"inherited,omitempty"`
 }
 
 const (
@@ -124,192 +82,252 @@ const (
 	CompanyDom       = DomPrefix + DivisionNameCompany
 )
 
-var allAllowPermissions []Permission
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrDivisionNotFound = errors.New("division not found")
+)
+
+// vocabulary lists the objects and actions permission matrices are built from,
+// in display order.
+var vocabulary = registry.Default()
 
 func main() {
-	e, err := casbin.NewEnforcer("model_my.conf")
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runServe implements "serve", the HTTP API.
+func runServe(args []string) int {
+	var opts options
+	var decisionOpts decisionLogOptions
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	opts.register(fs)
+	decisionOpts.register(fs)
+	addr := fs.String("addr", ":8080", "HTTP listen address")
+	templates := fs.String("templates", "", "JSON file of division role templates by division type")
+	reconcileInterval := fs.Duration("reconcile-interval", 0, "reconcile memberships with g rules on this interval, 0 disables")
+	reconcileFix := fs.Bool("reconcile-fix", false, "let scheduled reconciliation rewrite drifted g rules")
+	fs.Parse(args)
+
+	if *templates != "" {
+		if err := LoadDivisionTemplates(*templates); err != nil {
+			log.Fatalf("LoadDivisionTemplates: %v", err)
+		}
+	}
+
+	e, err := opts.openEnforcer()
 	if err != nil {
-		log.Fatalf("casbin.NewEnforcer: %v", err)
+		log.Fatalf("openEnforcer: %v", err)
+	}
+	repo, err := opts.openRepository(context.Background())
+	if err != nil {
+		log.Fatalf("openRepository: %v", err)
 	}
 
-	if err := setupEnforcer(e); err != nil {
-		log.Fatalf("setupEnforcer: %v", err)
+	accounts, err := account.NewManager("account/account.conf", "account/account.csv")
+	if err != nil {
+		log.Fatalf("account.NewManager: %v", err)
 	}
 
-	ctx := context.Background()
+	closeDecisionLog, err := decisionOpts.open(&opts)
+	if err != nil {
+		log.Fatalf("decisionLogOptions.open: %v", err)
+	}
 
-	allAllowPermissions = generateAllAllowPermissions()
+	tokens, err := opts.openActorTokens()
+	if err != nil {
+		log.Fatalf("openActorTokens: %v", err)
+	}
 
-	if _, err := ListUsersPermission(ctx, e); err != nil {
-		log.Fatalf("ListUsersPermission: %v", err)
+	s := newServer(e, repo, accounts, tokens.Authenticate)
+	if *reconcileInterval > 0 {
+		go s.reconcileEvery(context.Background(), *reconcileInterval, *reconcileFix)
 	}
-	ListDivisionsPermission(ctx, e)
-}
 
-func setupEnforcer(e *casbin.Enforcer) error {
-	e.SetFieldIndex("p", constant.SubjectIndex, 0)
-	e.SetFieldIndex("p", constant.DomainIndex, 1)
-	e.SetFieldIndex("p", constant.ObjectIndex, 2)
+	log.Printf("listening on %s", *addr)
+	err = http.ListenAndServe(*addr, s.routes())
+	closeDecisionLog()
+	log.Fatalf("http.ListenAndServe: %v", err)
+	return 0
+}
 
-	adapter := fileadapter.NewAdapter("policy_my.csv")
-	e.SetAdapter(adapter)
+func ListUsersPermission(ctx context.Context, e *Enforcer, repo Repository) ([]User, error) {
+	users, err := repo.ListUsers(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListUsers")
+	}
 
-	if err := e.LoadPolicy(); err != nil {
-		return errors.Wrap(err, "LoadPolicy")
+	for i := range users {
+		if err := fillUserPermissions(ctx, e, &users[i]); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("fillUserPermissions(ctx, e, %s)", users[i].Name))
+		}
 	}
-	return nil
+	return users, nil
 }
 
-func ListUsersPermission(ctx context.Context, e *casbin.Enforcer) ([]User, error) {
-	users := mockListUsersFromDB()
-
-	for i, user := range users {
-		mUserPermissions := make(map[string][]Action)
+func GetUserPermission(ctx context.Context, e *Enforcer, repo Repository, name string) (*User, error) {
+	user, err := repo.GetUser(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetUser")
+	}
+	if err := fillUserPermissions(ctx, e, user); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("fillUserPermissions(ctx, e, %s)", name))
+	}
+	return user, nil
+}
 
-		for _, divisionRole := range user.DivisionRoles {
-			user := UserPrefix + user.Name
-			dom := DomPrefix + string(divisionRole.Division.Name)
+func fillUserPermissions(ctx context.Context, e *Enforcer, user *User) error {
+	mUserPermissions := make(map[string]Permission)
 
-			rolePermissions, err := getUserPermissionsFromPolicy(ctx, e, user, dom)
-			if err != nil {
-				return nil, errors.Wrap(err, fmt.Sprintf("getUserPermissionsFromPolicy(ctx, e, %s, %s)", user, dom))
-			}
+	for _, divisionRole := range user.DivisionRoles {
+		sub := UserPrefix + user.Name
+		dom := DomPrefix + string(divisionRole.Division.Name)
 
-			// users[i].DivisionRoles[j].Permissions = rolePermissions
+		rolePermissions, err := getUserPermissionsFromPolicy(ctx, e, sub, dom)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("getUserPermissionsFromPolicy(ctx, e, %s, %s)", sub, dom))
+		}
 
-			for _, permission := range rolePermissions {
-				if existingActions, ok := mUserPermissions[permission.Name]; ok {
-					// Merge actions if the permission already exists
-					mUserPermissions[permission.Name] = mergeActions(existingActions, permission.Actions)
-				} else {
-					// Add the permission if it doesn't exist
-					mUserPermissions[permission.Name] = permission.Actions
-				}
+		for _, permission := range rolePermissions {
+			if existing, ok := mUserPermissions[permission.Name]; ok {
+				// Merge actions if the permission already exists
+				existing.Actions = mergeActions(existing.Actions, permission.Actions)
+				mUserPermissions[permission.Name] = existing
+			} else {
+				// Add the permission if it doesn't exist
+				mUserPermissions[permission.Name] = permission
 			}
 		}
+	}
 
-		var userPermissions []Permission
-		for name, actions := range mUserPermissions {
-			userPermissions = append(userPermissions, Permission{
-				Name:    name,
-				Actions: actions,
-			})
+	var userPermissions []Permission
+	for _, obj := range matrixObjects(e) {
+		if permission, ok := mUserPermissions[permissionName(obj)]; ok {
+			userPermissions = append(userPermissions, permission)
 		}
-		users[i].Permissions = userPermissions
 	}
-	return users, nil
+	user.Permissions = userPermissions
+	return nil
 }
 
 func mergeActions(existingActions, newActions []Action) []Action {
-	mergedMap := make(map[string]Action)
-
-	// Populate mergedMap with existingActions
-	for _, existingAction := range existingActions {
-		mergedMap[existingAction.Name] = existingAction
+	// Keep the order of existingActions, then any new names in their order.
+	mergedActions := append([]Action(nil), existingActions...)
+	index := make(map[string]int, len(mergedActions))
+	for i, existingAction := range mergedActions {
+		index[existingAction.Name] = i
 	}
 
-	// Update mergedMap with newActions
 	for _, newAction := range newActions {
-		if existingAction, exists := mergedMap[newAction.Name]; exists && newAction.Status {
-			existingAction.Status = true
-			mergedMap[newAction.Name] = existingAction
-		} else {
-			mergedMap[newAction.Name] = newAction
+		i, exists := index[newAction.Name]
+		if !exists {
+			index[newAction.Name] = len(mergedActions)
+			mergedActions = append(mergedActions, newAction)
+			continue
+		}
+		if newAction.Status && !mergedActions[i].Status {
+			mergedActions[i] = newAction
 		}
-	}
-
-	// Convert map values to slice
-	mergedActions := make([]Action, 0, len(mergedMap))
-	for _, action := range mergedMap {
-		mergedActions = append(mergedActions, action)
 	}
 
 	return mergedActions
 }
 
-func getUserPermissionsFromPolicy(ctx context.Context, e *casbin.Enforcer, user string, dom string) ([]Permission, error) {
-
-	ok, err := e.HasRoleForUser(user, string(RootRole), dom)
+// getUserPermissionsFromPolicy is the matrix of user in dom, as e decides
+// every entry of it.
+func getUserPermissionsFromPolicy(ctx context.Context, e *Enforcer, user string, dom string) ([]Permission, error) {
+	mPermissions, _, err := resolveDomainGrant(e, user, dom).permissions(e)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("HasRoleForUser(%s, %s, %s)", user, RootRole, dom))
-	}
-	if ok {
-		return allAllowPermissions, nil
+		return nil, err
 	}
+	return buildPermissionsFromMapping(e, mPermissions, nil), nil
+}
 
-	mPermissions := generatePermissionsMapping()
-	policy, err := e.GetImplicitPermissionsForUser(user, dom)
+func ListDivisionsPermission(ctx context.Context, e *Enforcer, repo Repository) ([]Division, error) {
+	divisions, err := repo.ListDivisions(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("GetImplicitPermissionsForUser(%s, %s)", user, dom))
+		return nil, errors.Wrap(err, "ListDivisions")
 	}
-	for _, p := range policy {
-		obj, act := p[2], p[3]
-		mPermissions[obj][act] = true
+
+	for i := range divisions {
+		if err := fillDivisionPermissions(ctx, e, &divisions[i]); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("fillDivisionPermissions(ctx, e, %s)", divisions[i].Name))
+		}
 	}
 
-	return buildPermissionsFromMapping(mPermissions), nil
+	return divisions, nil
 }
 
-func ListDivisionsPermission(ctx context.Context, e *casbin.Enforcer) []Division {
-	divisions := mockListDivisionsFromDB()
-
-	for i, division := range divisions {
-		for j, divisionRole := range division.DivisionRoles {
-			role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
-			// role := RolePrefix + string(divisionRole.Name) + ":" + fmt.Sprint(divisionRole.Level)
-			dom := DomPrefix + string(division.Name)
-
-			permissions := getRolePermissionsFromPolicy(ctx, e, role, dom)
-			divisions[i].DivisionRoles[j].Permissions = permissions
-		}
+func GetDivisionPermission(ctx context.Context, e *Enforcer, repo Repository, name DivisionName) (*Division, error) {
+	division, err := repo.GetDivision(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetDivision")
 	}
-
-	return divisions
+	if err := fillDivisionPermissions(ctx, e, division); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("fillDivisionPermissions(ctx, e, %s)", name))
+	}
+	return division, nil
 }
 
-func getRolePermissionsFromPolicy(ctx context.Context, e *casbin.Enforcer, role string, dom string) []Permission {
+func fillDivisionPermissions(ctx context.Context, e *Enforcer, division *Division) error {
+	for j, divisionRole := range division.DivisionRoles {
+		role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
+		// role := RolePrefix + string(divisionRole.Name) + ":" + fmt.Sprint(divisionRole.Level)
+		dom := DomPrefix + string(division.Name)
 
-	if strings.EqualFold(role, string(RootRole)) &&
-		strings.EqualFold(dom, string(CompanyDom)) {
-		return allAllowPermissions
+		permissions, err := getRolePermissionsFromPolicy(ctx, e, role, dom)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("getRolePermissionsFromPolicy(ctx, e, %s, %s)", role, dom))
+		}
+		division.DivisionRoles[j].Permissions = permissions
 	}
+	return nil
+}
 
-	mPermissions := generatePermissionsMapping()
-	policy := e.GetFilteredPolicy(0, role, dom)
-	for _, p := range policy {
-		obj, act := p[2], p[3]
-		mPermissions[obj][act] = true
+// getRolePermissionsFromPolicy is the matrix of role in dom, as e decides
+// every entry of it.
+func getRolePermissionsFromPolicy(ctx context.Context, e *Enforcer, role string, dom string) ([]Permission, error) {
+	mPermissions, mInherited, err := resolveDomainGrant(e, role, dom).permissions(e)
+	if err != nil {
+		return nil, err
 	}
-
-	return buildPermissionsFromMapping(mPermissions)
+	return buildPermissionsFromMapping(e, mPermissions, mInherited), nil
 }
 
-func generatePermissionsMapping() map[string]map[string]bool {
+func generatePermissionsMapping(e *Enforcer) map[string]map[string]bool {
 	mPermissions := make(map[string]map[string]bool)
-	for _, obj := range getAllObjects() {
+	for _, obj := range matrixObjects(e) {
 		mPermissions[obj] = make(map[string]bool)
-		for _, act := range getAllActions() {
+		for _, act := range vocabulary.Actions() {
 			mPermissions[obj][act] = false
 		}
 	}
 	return mPermissions
 }
 
-func buildPermissionsFromMapping(mPermissions map[string]map[string]bool) []Permission {
+func buildPermissionsFromMapping(e *Enforcer, mPermissions map[string]map[string]bool, mInherited map[string]map[string]bool) []Permission {
 	var permissions []Permission
 
-	for obj, mAct := range mPermissions {
+	for _, obj := range matrixObjects(e) {
+		mAct, ok := mPermissions[obj]
+		if !ok {
+			continue
+		}
 		var actions []Action
-		for act, eft := range mAct {
+		for _, act := range vocabulary.Actions() {
 			actions = append(actions, Action{
-				Name:   strings.TrimPrefix(act, ActPrefix),
-				Status: eft,
+				Name:      strings.TrimPrefix(act, ActPrefix),
+				Status:    mAct[act],
+				Inherited: mInherited[obj][act],
 			})
 		}
-		permissions = append(permissions, Permission{
-			Name:    strings.TrimPrefix(obj, ObjPrefix),
+		permission := Permission{
+			Name:    permissionName(obj),
 			Actions: actions,
-		})
+		}
+		if strings.HasPrefix(obj, BundlePrefix) {
+			permission.Members = bundleMembers(e, obj)
+		}
+		permissions = append(permissions, permission)
 	}
 
 	return permissions
@@ -317,11 +335,11 @@ func buildPermissionsFromMapping(mPermissions map[string]map[string]bool) []Perm
 
 func generateAllAllowPermissions() []Permission {
 	// Preallocates the slice based on the number of objects for efficiency.
-	permissions := make([]Permission, 0, len(getAllTrimmedObjects()))
+	permissions := make([]Permission, 0, len(vocabulary.TrimmedObjects()))
 
-	for _, obj := range getAllTrimmedObjects() {
+	for _, obj := range vocabulary.TrimmedObjects() {
 		permission := Permission{Name: obj}
-		for _, act := range getAllTrimmedActions() {
+		for _, act := range vocabulary.TrimmedActions() {
 			permission.Actions = append(permission.Actions, Action{
 				Name:   act,
 				Status: true,
@@ -332,7 +350,7 @@ func generateAllAllowPermissions() []Permission {
 	return permissions
 }
 
-func mockListUsersFromDB() []User {
+func seedUsers() []User {
 	return []User{
 		User{
 			Name: "jason",
@@ -423,7 +441,7 @@ func mockListUsersFromDB() []User {
 	}
 }
 
-func mockListDivisionsFromDB() []Division {
+func seedDivisions() []Division {
 	return []Division{
 		Division{
 			Name: DivisionNameCompany,
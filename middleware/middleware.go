@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ObjPrefix = "obj:"
+	ActPrefix = "act:"
+)
+
+// Enforcer is satisfied by *casbin.Enforcer and *casbin.SyncedEnforcer.
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// Extractor returns the subject and domain a request acts as, for example
+// "user:ian" and "dom:marketing".
+type Extractor func(r *http.Request) (sub string, dom string, err error)
+
+// Route maps a method on a path to the object and action it needs. A Path
+// ending in "/" matches the whole subtree, as with http.ServeMux.
+type Route struct {
+	Method string
+	Path   string
+	Obj    string
+	Act    string
+}
+
+type Config struct {
+	Enforcer  Enforcer
+	Extractor Extractor
+	Routes    []Route
+
+	// Objects and Actions, when set, restrict the values Routes may use.
+	Objects []string
+	Actions []string
+}
+
+type Middleware struct {
+	enforcer  Enforcer
+	extractor Extractor
+	routes    []Route
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Sub   string `json:"sub,omitempty"`
+	Dom   string `json:"dom,omitempty"`
+	Obj   string `json:"obj,omitempty"`
+	Act   string `json:"act,omitempty"`
+}
+
+func New(cfg Config) (*Middleware, error) {
+	if cfg.Enforcer == nil {
+		return nil, errors.New("enforcer is required")
+	}
+	if cfg.Extractor == nil {
+		return nil, errors.New("extractor is required")
+	}
+	for _, route := range cfg.Routes {
+		if err := validateRoute(route, cfg.Objects, cfg.Actions); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("%s %s", route.Method, route.Path))
+		}
+	}
+
+	// Longest paths first so the most specific route wins.
+	routes := append([]Route(nil), cfg.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Path) > len(routes[j].Path)
+	})
+
+	return &Middleware{
+		enforcer:  cfg.Enforcer,
+		extractor: cfg.Extractor,
+		routes:    routes,
+	}, nil
+}
+
+// CRUD maps the usual REST methods on path to the read, create, update and
+// delete actions of obj.
+func CRUD(path string, obj string) []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: path, Obj: obj, Act: ActPrefix + "read"},
+		{Method: http.MethodPost, Path: path, Obj: obj, Act: ActPrefix + "create"},
+		{Method: http.MethodPut, Path: path, Obj: obj, Act: ActPrefix + "update"},
+		{Method: http.MethodPatch, Path: path, Obj: obj, Act: ActPrefix + "update"},
+		{Method: http.MethodDelete, Path: path, Obj: obj, Act: ActPrefix + "delete"},
+	}
+}
+
+// HeaderExtractor reads the subject and domain from request headers.
+func HeaderExtractor(subHeader string, domHeader string) Extractor {
+	return func(r *http.Request) (string, string, error) {
+		sub, dom := r.Header.Get(subHeader), r.Header.Get(domHeader)
+		if sub == "" || dom == "" {
+			return "", "", errors.Errorf("missing %s or %s header", subHeader, domHeader)
+		}
+		return sub, dom, nil
+	}
+}
+
+// Handler lets a request through only when the enforcer allows its subject
+// to perform the route's action on the route's object. Requests that match
+// no route are denied.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := m.match(r)
+		if !ok {
+			writeJSON(w, http.StatusForbidden, errorResponse{
+				Error: fmt.Sprintf("no authorization rule for %s %s", r.Method, r.URL.Path),
+			})
+			return
+		}
+
+		sub, dom, err := m.extractor(r)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: err.Error()})
+			return
+		}
+
+		ok, err = m.enforcer.Enforce(sub, dom, route.Obj, route.Act)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: errors.Wrap(err, "Enforce").Error()})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusForbidden, errorResponse{
+				Error: "forbidden",
+				Sub:   sub,
+				Dom:   dom,
+				Obj:   route.Obj,
+				Act:   route.Act,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middleware) match(r *http.Request) (Route, bool) {
+	for _, route := range m.routes {
+		if route.Method != r.Method {
+			continue
+		}
+		if route.Path == r.URL.Path ||
+			strings.HasSuffix(route.Path, "/") && strings.HasPrefix(r.URL.Path, route.Path) {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+func validateRoute(route Route, objects []string, actions []string) error {
+	if route.Method == "" || route.Path == "" {
+		return errors.New("method and path are required")
+	}
+	if err := validateValue(route.Obj, ObjPrefix, objects); err != nil {
+		return err
+	}
+	return validateValue(route.Act, ActPrefix, actions)
+}
+
+func validateValue(value string, prefix string, known []string) error {
+	if !strings.HasPrefix(value, prefix) || value == prefix {
+		return errors.Errorf("%q must start with %q", value, prefix)
+	}
+	if known == nil {
+		return nil
+	}
+	for _, k := range known {
+		if k == value {
+			return nil
+		}
+	}
+	return errors.Errorf("%q is not a known value", value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// stubEnforcer allows the "sub, dom, obj, act" requests it holds, and fails
+// for the subject "error".
+type stubEnforcer map[[4]string]bool
+
+func (s stubEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	var req [4]string
+	for i, rval := range rvals {
+		req[i] = rval.(string)
+	}
+	if req[0] == "error" {
+		return false, errors.New("enforcer failed")
+	}
+	return s[req], nil
+}
+
+func TestHandler(t *testing.T) {
+	enforcer := stubEnforcer{
+		{"user:ian", "dom:marketing", "obj:news", "act:read"}:     true,
+		{"user:ian", "dom:marketing", "obj:news_tag", "act:read"}: true,
+		{"user:ian", "dom:marketing", "obj:account", "act:read"}:  true,
+	}
+	routes := append(CRUD("/news", ObjPrefix+"news"),
+		Route{Method: http.MethodGet, Path: "/news/tags/", Obj: ObjPrefix + "news_tag", Act: ActPrefix + "read"},
+		Route{Method: http.MethodGet, Path: "/accounts/", Obj: ObjPrefix + "account", Act: ActPrefix + "read"},
+	)
+	m, err := New(Config{
+		Enforcer:  enforcer,
+		Extractor: HeaderExtractor("X-Sub", "X-Dom"),
+		Routes:    routes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		sub    string
+		want   int
+		body   errorResponse
+	}{
+		{"allowed", http.MethodGet, "/news", "user:ian", http.StatusNoContent, errorResponse{}},
+		{"denied", http.MethodDelete, "/news", "user:ian", http.StatusForbidden,
+			errorResponse{Error: "forbidden", Sub: "user:ian", Dom: "dom:marketing", Obj: "obj:news", Act: "act:delete"}},
+		{"another subject", http.MethodGet, "/news", "user:zoe", http.StatusForbidden,
+			errorResponse{Error: "forbidden", Sub: "user:zoe", Dom: "dom:marketing", Obj: "obj:news", Act: "act:read"}},
+		{"subtree", http.MethodGet, "/accounts/42", "user:ian", http.StatusNoContent, errorResponse{}},
+		{"longest path wins", http.MethodGet, "/news/tags/7", "user:ian", http.StatusNoContent, errorResponse{}},
+		{"exact path only", http.MethodGet, "/news/7", "user:ian", http.StatusForbidden,
+			errorResponse{Error: "no authorization rule for GET /news/7"}},
+		{"no route for the method", http.MethodPost, "/accounts/42", "user:ian", http.StatusForbidden,
+			errorResponse{Error: "no authorization rule for POST /accounts/42"}},
+		{"no subject", http.MethodGet, "/news", "", http.StatusUnauthorized,
+			errorResponse{Error: "missing X-Sub or X-Dom header"}},
+		{"enforcer error", http.MethodGet, "/news", "error", http.StatusInternalServerError,
+			errorResponse{Error: "Enforce: enforcer failed"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.sub != "" {
+			r.Header.Set("X-Sub", tt.sub)
+		}
+		r.Header.Set("X-Dom", "dom:marketing")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+			continue
+		}
+		if tt.want == http.StatusNoContent {
+			continue
+		}
+		var body errorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if body != tt.body {
+			t.Errorf("%s: body %+v, want %+v", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	extractor := HeaderExtractor("X-Sub", "X-Dom")
+	objects := []string{"obj:news"}
+	actions := []string{"act:read"}
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"valid", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "/news", "obj:news", "act:read"}}}, true},
+		{"known values", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "/news", "obj:news", "act:read"}}, Objects: objects, Actions: actions}, true},
+		{"no enforcer", Config{Extractor: extractor}, false},
+		{"no extractor", Config{Enforcer: stubEnforcer{}}, false},
+		{"no method", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"", "/news", "obj:news", "act:read"}}}, false},
+		{"no path", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "", "obj:news", "act:read"}}}, false},
+		{"object without prefix", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "/news", "news", "act:read"}}}, false},
+		{"bare action prefix", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "/news", "obj:news", "act:"}}}, false},
+		{"unknown object", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"GET", "/blog", "obj:blog", "act:read"}}, Objects: objects, Actions: actions}, false},
+		{"unknown action", Config{Enforcer: stubEnforcer{}, Extractor: extractor, Routes: []Route{{"POST", "/news", "obj:news", "act:create"}}, Objects: objects, Actions: actions}, false},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); (err == nil) != tt.ok {
+			t.Errorf("%s: New = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// CSVRule is one rule of a policy CSV file and the line it was read from.
+type CSVRule struct {
+	Line  int      `json:"line,omitempty"`
+	Ptype string   `json:"ptype"`
+	Rule  []string `json:"rule"`
+}
+
+type InvalidRule struct {
+	CSVRule
+	Reason string `json:"reason"`
+}
+
+// RuleConflict is a rule that matches a stored rule on everything but its
+// effect, such as an allow where the database has a deny.
+type RuleConflict struct {
+	CSVRule
+	Stored []string `json:"stored"`
+}
+
+// RuleMigration reports what MigrateRules did, or would do on a dry run.
+type RuleMigration struct {
+	DryRun    bool           `json:"dryRun"`
+	Inserted  int            `json:"inserted"`
+	Skipped   int            `json:"skipped"`
+	Conflicts []RuleConflict `json:"conflicts"`
+	Invalid   []InvalidRule  `json:"invalid,omitempty"`
+}
+
+// readPolicyCSV reads the rules of a policy CSV file, skipping blank lines
+// and "#" comments.
+func readPolicyCSV(r io.Reader) ([]CSVRule, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	var rules []CSVRule
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "csv.Read")
+		}
+		line, _ := reader.FieldPos(0)
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		rules = append(rules, CSVRule{Line: line, Ptype: record[0], Rule: record[1:]})
+	}
+}
+
+// validateRule checks rule against the p and g definitions of m.
+func validateRule(m model.Model, rule CSVRule) string {
+	assertion := ruleAssertion(m, rule.Ptype)
+	if assertion == nil {
+		return fmt.Sprintf("unknown policy type %q", rule.Ptype)
+	}
+	if len(rule.Rule) != len(assertion.Tokens) {
+		return fmt.Sprintf("%d values, the model defines %d", len(rule.Rule), len(assertion.Tokens))
+	}
+	if len(rule.Rule) > 6 {
+		return "more than 6 values do not fit casbin_rule"
+	}
+	for i, value := range rule.Rule {
+		if value == "" {
+			return fmt.Sprintf("empty value %d", i)
+		}
+	}
+	if hasEffect(m, rule.Ptype) {
+		if eft := rule.Rule[len(rule.Rule)-1]; eft != EffectAllow && eft != EffectDeny {
+			return fmt.Sprintf("effect %q is neither %s nor %s", eft, EffectAllow, EffectDeny)
+		}
+	}
+	return ""
+}
+
+func ruleAssertion(m model.Model, ptype string) *model.Assertion {
+	for _, sec := range []string{"p", "g"} {
+		if assertion, ok := m[sec][ptype]; ok {
+			return assertion
+		}
+	}
+	return nil
+}
+
+// hasEffect reports whether the last value of ptype rules is their effect.
+func hasEffect(m model.Model, ptype string) bool {
+	assertion := ruleAssertion(m, ptype)
+	if assertion == nil || len(assertion.Tokens) == 0 {
+		return false
+	}
+	return assertion.Tokens[len(assertion.Tokens)-1] == ptype+"_eft"
+}
+
+// ruleKey identifies a rule regardless of its effect.
+func ruleKey(m model.Model, ptype string, values []string) string {
+	if hasEffect(m, ptype) && len(values) > 0 {
+		values = values[:len(values)-1]
+	}
+	return ptype + ", " + strings.Join(values, ", ")
+}
+
+// MigrateRules adds rules to the casbin_rule table of gdb in one transaction.
+// Rules already stored are skipped, so running it again inserts nothing.
+// Rules that differ from a stored rule only by effect are reported as
+// conflicts and left out. Invalid rules fail the migration before anything
+// is written. The inserted rules are then recorded in the audit trail of repo
+// as MigrateActor, and removed again when that fails. A dry run reports the
+// same counts without writing.
+func MigrateRules(ctx context.Context, gdb *gorm.DB, repo Repository, m model.Model, rules []CSVRule, dryRun bool) (*RuleMigration, error) {
+	migration := &RuleMigration{
+		DryRun:    dryRun,
+		Conflicts: []RuleConflict{},
+	}
+	for _, rule := range rules {
+		if reason := validateRule(m, rule); reason != "" {
+			migration.Invalid = append(migration.Invalid, InvalidRule{CSVRule: rule, Reason: reason})
+		}
+	}
+	if len(migration.Invalid) > 0 {
+		return migration, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%d invalid rules", len(migration.Invalid)))
+	}
+
+	if !dryRun {
+		if _, err := db.NewAdapter(gdb); err != nil {
+			return nil, errors.Wrap(err, "db.NewAdapter")
+		}
+	}
+
+	var rows []db.CasbinRule
+	var inserted []CSVRule
+	err := gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var stored []db.CasbinRule
+		if tx.Migrator().HasTable(&db.CasbinRule{}) {
+			if err := tx.Order("id").Find(&stored).Error; err != nil {
+				return errors.Wrap(err, "Find")
+			}
+		}
+		known := make(map[string][]string, len(stored))
+		for _, row := range stored {
+			known[ruleKey(m, row.Ptype, row.Values())] = row.Values()
+		}
+
+		for _, rule := range rules {
+			key := ruleKey(m, rule.Ptype, rule.Rule)
+			values, ok := known[key]
+			switch {
+			case !ok:
+				known[key] = rule.Rule
+				rows = append(rows, db.NewCasbinRule(rule.Ptype, rule.Rule))
+				inserted = append(inserted, rule)
+			case strings.Join(values, ", ") == strings.Join(rule.Rule, ", "):
+				migration.Skipped++
+			default:
+				migration.Conflicts = append(migration.Conflicts, RuleConflict{CSVRule: rule, Stored: values})
+			}
+		}
+		migration.Inserted = len(rows)
+		if dryRun || len(rows) == 0 {
+			return nil
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return errors.Wrap(err, "Create")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction")
+	}
+	if dryRun || len(rows) == 0 {
+		return migration, nil
+	}
+	// Like recordPolicyDeltas, take the rules out again when they cannot be
+	// recorded, so that no change goes unrecorded.
+	if err := recordRuleRewrite(ctx, repo, m, MigrateActor, nil, inserted); err != nil {
+		if deleteErr := gdb.WithContext(ctx).Delete(&rows).Error; deleteErr != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Delete: %v", deleteErr))
+		}
+		return nil, err
+	}
+	return migration, nil
+}
+
+// runMigrate implements "migrate -in <csv> -policy <database>". It returns 1
+// when some rules conflict with stored ones, and 2 on errors.
+func runMigrate(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	opts.register(fs)
+	in := fs.String("in", DefaultPolicyPath, "CSV file to migrate")
+	dryRun := fs.Bool("dry-run", false, "report what would be inserted without writing")
+	format := fs.String("format", "text", "output format, text or json")
+	reason := fs.String("reason", "", "reason recorded in the audit trail")
+	fs.Parse(args)
+
+	ctx := WithAuditReason(context.Background(), *reason)
+	m, err := model.NewModelFromFile(opts.modelPath)
+	if err != nil {
+		return fail("migrate", errors.Wrap(err, "model.NewModelFromFile"))
+	}
+	gdb, err := policyDB(opts.policy)
+	if err != nil {
+		return fail("migrate", err)
+	}
+	if gdb == nil {
+		return fail("migrate", errors.Wrap(ErrInvalidRequest, "-policy must be a sqlite: or mysql: source"))
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		return fail("migrate", errors.Wrap(err, "os.Open"))
+	}
+	defer f.Close()
+	rules, err := readPolicyCSV(f)
+	if err != nil {
+		return fail("migrate", errors.Wrap(err, fmt.Sprintf("readPolicyCSV(%s)", *in)))
+	}
+
+	repo, err := opts.openRepository(ctx)
+	if err != nil {
+		return fail("migrate", err)
+	}
+	migration, err := MigrateRules(ctx, gdb, repo, m, rules, *dryRun)
+	if migration != nil {
+		if *format == "json" {
+			if err := printJSON(migration); err != nil {
+				return fail("migrate", err)
+			}
+		} else {
+			writeMigrationText(os.Stdout, *in, migration)
+		}
+	}
+	if err != nil {
+		return fail("migrate", err)
+	}
+	if len(migration.Conflicts) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeMigrationText(w io.Writer, path string, migration *RuleMigration) {
+	for _, rule := range migration.Invalid {
+		fmt.Fprintf(w, "%s:%d: invalid: %s\n", path, rule.Line, rule.Reason)
+	}
+	if len(migration.Invalid) > 0 {
+		return
+	}
+	for _, conflict := range migration.Conflicts {
+		fmt.Fprintf(w, "%s:%d: conflict: %s, %s; stored %s, %s\n", path, conflict.Line,
+			conflict.Ptype, strings.Join(conflict.Rule, ", "), conflict.Ptype, strings.Join(conflict.Stored, ", "))
+	}
+	verb := "inserted"
+	if migration.DryRun {
+		verb = "would insert"
+	}
+	fmt.Fprintf(w, "%s %d, skipped %d, conflicts %d\n", verb, migration.Inserted, migration.Skipped, len(migration.Conflicts))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/pkg/errors"
+)
+
+func TestMigrateRules(t *testing.T) {
+	ctx := context.Background()
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb, err := db.OpenSQLite(filepath.Join(t.TempDir(), "policy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := openTestRepository(t)
+	const policy = `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+g, user:ian, role:editor:1, dom:marketing
+g3, act:read, act:all
+`
+
+	steps := []struct {
+		name      string
+		policy    string
+		dryRun    bool
+		inserted  int
+		skipped   int
+		conflicts string
+		stored    int64
+	}{
+		{"dry run", policy, true, 3, 0, "[]", 0},
+		{"first run", policy, false, 3, 0, "[]", 3},
+		{"second run", policy, false, 0, 3, "[]", 3},
+		{
+			name: "another effect and a new rule",
+			policy: `
+p, role:editor:1, dom:marketing, obj:news, act:read, deny
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+p, role:editor:1, dom:marketing, obj:news, act:create, allow
+`,
+			inserted:  1,
+			skipped:   1,
+			conflicts: "[{{1 p [role:editor:1 dom:marketing obj:news act:read deny]} [role:editor:1 dom:marketing obj:news act:read allow]}]",
+			stored:    4,
+		},
+	}
+	for _, step := range steps {
+		migration, err := MigrateRules(ctx, gdb, repo, m, readTestRules(t, step.policy), step.dryRun)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if migration.DryRun != step.dryRun || migration.Inserted != step.inserted || migration.Skipped != step.skipped ||
+			fmt.Sprint(migration.Conflicts) != step.conflicts {
+			t.Errorf("%s: %+v, want %d inserted, %d skipped and conflicts %s", step.name, migration, step.inserted, step.skipped, step.conflicts)
+		}
+		var stored int64
+		if gdb.Migrator().HasTable(&db.CasbinRule{}) {
+			if err := gdb.Model(&db.CasbinRule{}).Count(&stored).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stored != step.stored {
+			t.Errorf("%s: %d stored rules, want %d", step.name, stored, step.stored)
+		}
+	}
+
+	// Invalid rules fail the whole migration.
+	migration, err := MigrateRules(ctx, gdb, repo, m, readTestRules(t, `
+p, role:editor:1, dom:marketing, obj:period, act:read, allow
+p, role:editor:1, dom:marketing, obj:news
+`), false)
+	if errors.Cause(err) != ErrInvalidRequest || len(migration.Invalid) != 1 || migration.Invalid[0].Line != 2 {
+		t.Errorf("invalid rules: %+v, %v", migration, err)
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	m, err := model.NewModelFromFile(DefaultModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"p, role:editor:1, dom:marketing, obj:news, act:read, allow", ""},
+		{"p, role:editor:1, dom:marketing, obj:news, act:read, deny", ""},
+		{"g, user:ian, role:editor:1, dom:marketing", ""},
+		{"g2, obj:news, subscription:media", ""},
+		{"g3, act:read, act:all", ""},
+		{"p, role:editor:1, dom:marketing, obj:news, act:read", "4 values, the model defines 5"},
+		{"p, role:editor:1, dom:marketing, obj:news, act:read, maybe", `effect "maybe" is neither allow nor deny`},
+		{"g, user:ian, , dom:marketing", "empty value 1"},
+		{"g2, obj:news, subscription:media, dom:marketing", "3 values, the model defines 2"},
+		{"g4, act:read, act:all", `unknown policy type "g4"`},
+	}
+	for _, tt := range tests {
+		rules := readTestRules(t, tt.rule)
+		if got := validateRule(m, rules[0]); got != tt.want {
+			t.Errorf("validateRule(%s) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
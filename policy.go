@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/pkg/errors"
+)
+
+// policyDelta is the set of rules to remove from and add to one ptype.
+type policyDelta struct {
+	ptype   string
+	removed [][]string
+	added   [][]string
+}
+
+// UpdateRolePermissions rewrites the p rules of divisionRole so that they
+// match permissions, and returns the resulting matrix. Objects and actions
+// missing from permissions are left untouched.
+func UpdateRolePermissions(ctx context.Context, e *Enforcer, repo Repository, actor string, divisionRole DivisionRole, permissions []Permission) ([]Permission, error) {
+	if divisionRole.Division == nil {
+		return nil, errors.Wrap(ErrInvalidRequest, "division is required")
+	}
+	role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
+	dom := DomPrefix + string(divisionRole.Division.Name)
+
+	if role == string(RootRole) && dom == string(CompanyDom) {
+		return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("%s in %s is granted everything implicitly", role, dom))
+	}
+	if err := checkDelegation(e, actor, role, dom); err != nil {
+		return nil, errors.Wrap(err, "checkDelegation")
+	}
+
+	delta, err := diffRolePermissions(e, role, dom, permissions)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("diffRolePermissions(e, %s, %s)", role, dom))
+	}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return nil, errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.CreateDivisionRole(ctx, divisionRole); err != nil {
+		return nil, revertPolicyDeltas(e, errors.Wrap(err, "CreateDivisionRole"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return nil, errors.Wrap(err, "recordPolicyDeltas")
+	}
+
+	return getRolePermissionsFromPolicy(ctx, e, role, dom)
+}
+
+func diffRolePermissions(e *Enforcer, role string, dom string, permissions []Permission) (policyDelta, error) {
+	desired, err := permissionsToMapping(permissions)
+	if err != nil {
+		return policyDelta{}, err
+	}
+	if err := checkBundles(e, desired); err != nil {
+		return policyDelta{}, err
+	}
+
+	current := e.GetFilteredPolicy(0, role, dom)
+	rules := append([][]string(nil), current...)
+
+	// Bundles come first so that splitting a grant keeps it as coarse as
+	// possible. Grants are applied before revokes, so a revoke wins over a
+	// grant that would cover it.
+	cells := append(bundles(e), vocabulary.Objects()...)
+	for _, eft := range []bool{true, false} {
+		for _, obj := range cells {
+			for _, act := range vocabulary.Actions() {
+				status, ok := desired[obj][act]
+				if !ok || status != eft || rulesAllow(e, rules, obj, act) == eft {
+					continue
+				}
+				if eft {
+					rules = grantRule(e, rules, role, dom, obj, act)
+				} else {
+					rules = revokeRule(e, rules, cells, obj, act)
+				}
+			}
+		}
+	}
+
+	return policyDelta{
+		ptype:   "p",
+		removed: subtractRules(current, rules),
+		added:   subtractRules(rules, current),
+	}, nil
+}
+
+// rulesAllow reports whether the first of rules covering obj/act allows it.
+func rulesAllow(e *Enforcer, rules [][]string, obj string, act string) bool {
+	for _, rule := range rules {
+		if ruleCovers(e, rule, obj, act) {
+			return ruleEffect(e, rule) == EffectAllow
+		}
+	}
+	return false
+}
+
+// grantRule drops the deny rules covering obj/act and adds an allow rule for
+// it unless another rule already allows it.
+func grantRule(e *Enforcer, rules [][]string, role string, dom string, obj string, act string) [][]string {
+	var kept [][]string
+	for _, rule := range rules {
+		if ruleEffect(e, rule) == EffectDeny && ruleCovers(e, rule, obj, act) {
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	if !rulesAllow(e, kept, obj, act) {
+		kept = append(kept, []string{role, dom, obj, act, EffectAllow})
+	}
+	return kept
+}
+
+// revokeRule drops the allow rules covering obj/act. A dropped grant on a
+// bundle or action group, such as act:all, is split into rules for what it
+// covered apart from obj/act. Whatever implies obj/act, as act:create
+// implies act:create_limited, is revoked with it.
+func revokeRule(e *Enforcer, rules [][]string, cells []string, obj string, act string) [][]string {
+	for rulesAllow(e, rules, obj, act) {
+		var dropped []string
+		var kept [][]string
+		for _, rule := range rules {
+			if dropped == nil && ruleCovers(e, rule, obj, act) {
+				dropped = rule
+				continue
+			}
+			kept = append(kept, rule)
+		}
+
+		for _, o := range cells {
+			for _, a := range vocabulary.Actions() {
+				if !ruleCovers(e, dropped, o, a) || (impliesObject(e, o, obj) && impliesAction(e, a, act)) {
+					continue
+				}
+				if rulesAllow(e, rules, o, a) && !rulesAllow(e, kept, o, a) {
+					kept = append(kept, []string{dropped[0], dropped[1], o, a, EffectAllow})
+				}
+			}
+		}
+		rules = kept
+	}
+	return rules
+}
+
+// checkBundles rejects bundles in mPermissions that no ObjectGroupType rule
+// defines.
+func checkBundles(e *Enforcer, mPermissions map[string]map[string]bool) error {
+	for obj := range mPermissions {
+		if !knownObject(e, obj) {
+			return errors.Wrap(ErrInvalidRequest, fmt.Sprintf("unknown bundle %q", obj))
+		}
+	}
+	return nil
+}
+
+// permissionsToMapping is the inverse of buildPermissionsFromMapping. Only the
+// objects and actions present in permissions, and not inherited, appear in
+// the result.
+func permissionsToMapping(permissions []Permission) (map[string]map[string]bool, error) {
+	mPermissions := make(map[string]map[string]bool)
+	for _, permission := range permissions {
+		// Bundles are checked against the policy by checkBundles.
+		obj := permissionObject(permission.Name)
+		if !vocabulary.HasObject(obj) && !hasNamePrefix(obj, []string{BundlePrefix}) {
+			return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("unknown object %q", permission.Name))
+		}
+		if _, ok := mPermissions[obj]; !ok {
+			mPermissions[obj] = make(map[string]bool)
+		}
+		for _, action := range permission.Actions {
+			if !vocabulary.HasAction(ActPrefix + action.Name) {
+				return nil, errors.Wrap(ErrInvalidRequest, fmt.Sprintf("unknown action %q on %q", action.Name, permission.Name))
+			}
+			if action.Inherited {
+				// Inherited statuses belong to another role and are not edited here.
+				continue
+			}
+			mPermissions[obj][ActPrefix+action.Name] = action.Status
+		}
+	}
+	return mPermissions, nil
+}
+
+// applyPolicyDeltas applies every delta or none of them. With the gorm
+// adapter the changes share one database transaction; with any other adapter
+// they are applied in memory, saved as a whole and reloaded on failure.
+func applyPolicyDeltas(e *Enforcer, deltas ...policyDelta) error {
+	if adapter, ok := e.GetAdapter().(*gormadapter.Adapter); ok {
+		err := adapter.Transaction(e, func(e casbin.IEnforcer) error {
+			return applyPolicyDeltasTo(e, deltas)
+		})
+		if err != nil {
+			return err
+		}
+		return preparePolicy(e)
+	}
+
+	if err := applyPolicyDeltasTo(e, deltas); err != nil {
+		return reloadAfter(e, err)
+	}
+	if err := preparePolicy(e); err != nil {
+		return reloadAfter(e, err)
+	}
+	if err := e.SavePolicy(); err != nil {
+		return reloadAfter(e, errors.Wrap(err, "SavePolicy"))
+	}
+	return nil
+}
+
+// preparePolicy restores what e derives from its rules once they change: the
+// order that subjectPriority relies on, with users before their roles, under
+// level inheritance the links between levels, and the policy version of
+// logged decisions. Rules added at runtime are appended instead.
+func preparePolicy(e *Enforcer) error {
+	defer policyChanged(e)
+	if err := e.GetModel().SortPoliciesBySubjectHierarchy(); err != nil {
+		return errors.Wrap(err, "SortPoliciesBySubjectHierarchy")
+	}
+	if !levelInheritance {
+		return nil
+	}
+	sortByLevel(e.GetModel())
+	// Rebuilding drops the links to levels that no longer exist.
+	if err := e.BuildRoleLinks(); err != nil {
+		return errors.Wrap(err, "BuildRoleLinks")
+	}
+	return linkLevels(e)
+}
+
+func applyPolicyDeltasTo(e casbin.IEnforcer, deltas []policyDelta) error {
+	for _, delta := range deltas {
+		grouping := strings.HasPrefix(delta.ptype, "g")
+
+		if len(delta.removed) > 0 {
+			var ok bool
+			var err error
+			if grouping {
+				ok, err = e.RemoveNamedGroupingPolicies(delta.ptype, delta.removed)
+			} else {
+				ok, err = e.RemoveNamedPolicies(delta.ptype, delta.removed)
+			}
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("remove %s rules", delta.ptype))
+			}
+			if !ok {
+				return errors.Errorf("remove %s rules: rules no longer exist", delta.ptype)
+			}
+		}
+
+		if len(delta.added) > 0 {
+			var ok bool
+			var err error
+			if grouping {
+				ok, err = e.AddNamedGroupingPolicies(delta.ptype, delta.added)
+			} else {
+				ok, err = e.AddNamedPolicies(delta.ptype, delta.added)
+			}
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("add %s rules", delta.ptype))
+			}
+			if !ok {
+				return errors.Errorf("add %s rules: rules already exist", delta.ptype)
+			}
+		}
+	}
+	return nil
+}
+
+// revertPolicyDeltas undoes deltas that were applied before a later step
+// failed with err, and returns err.
+func revertPolicyDeltas(e *Enforcer, err error, deltas ...policyDelta) error {
+	inverse := make([]policyDelta, 0, len(deltas))
+	for i := len(deltas) - 1; i >= 0; i-- {
+		inverse = append(inverse, policyDelta{
+			ptype:   deltas[i].ptype,
+			removed: deltas[i].added,
+			added:   deltas[i].removed,
+		})
+	}
+	if revertErr := applyPolicyDeltas(e, inverse...); revertErr != nil {
+		return errors.Wrap(err, fmt.Sprintf("revert policy: %v", revertErr))
+	}
+	return err
+}
+
+func reloadAfter(e *Enforcer, err error) error {
+	if loadErr := e.LoadPolicy(); loadErr != nil {
+		return errors.Wrap(err, fmt.Sprintf("LoadPolicy: %v", loadErr))
+	}
+	if prepareErr := preparePolicy(e); prepareErr != nil {
+		return errors.Wrap(err, fmt.Sprintf("preparePolicy: %v", prepareErr))
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// deltaLines formats rules as sorted policy lines.
+func deltaLines(rules [][]string) []string {
+	lines := []string{}
+	for _, rule := range rules {
+		lines = append(lines, strings.Join(rule, ", "))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestDiffRolePermissions(t *testing.T) {
+	const role, dom = "role:editor:1", "dom:marketing"
+	tests := []struct {
+		name        string
+		rules       string
+		permissions []Permission
+		removed     []string
+		added       []string
+		err         error
+	}{
+		{
+			name:        "grant",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: true}}}},
+			removed:     []string{},
+			added:       []string{"role:editor:1, dom:marketing, obj:news, act:read, allow"},
+		},
+		{
+			name:        "grant already covered by a group",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:all, allow",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "create_limited", Status: true}}}},
+			removed:     []string{},
+			added:       []string{},
+		},
+		{
+			name:        "grant replaces a deny",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:read, deny",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: true}}}},
+			removed:     []string{"role:editor:1, dom:marketing, obj:news, act:read, deny"},
+			added:       []string{"role:editor:1, dom:marketing, obj:news, act:read, allow"},
+		},
+		{
+			name:        "revoke keeps an explicit deny",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:read, deny",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: false}}}},
+			removed:     []string{},
+			added:       []string{},
+		},
+		{
+			name:        "revoke splits an action group",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:all, allow",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "delete", Status: false}}}},
+			removed:     []string{"role:editor:1, dom:marketing, obj:news, act:all, allow"},
+			added: []string{
+				"role:editor:1, dom:marketing, obj:news, act:create, allow",
+				"role:editor:1, dom:marketing, obj:news, act:delete_limited, allow",
+				"role:editor:1, dom:marketing, obj:news, act:read, allow",
+				"role:editor:1, dom:marketing, obj:news, act:update, allow",
+			},
+		},
+		{
+			name:        "revoke also revokes what implies it",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:create, allow",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "create_limited", Status: false}}}},
+			removed:     []string{"role:editor:1, dom:marketing, obj:news, act:create, allow"},
+			added:       []string{},
+		},
+		{
+			name: "revoke splits a bundle",
+			rules: `
+p, role:editor:1, dom:marketing, subscription:media, act:read, allow
+g2, obj:news, subscription:media
+g2, obj:news_tag, subscription:media
+`,
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: false}}}},
+			removed:     []string{"role:editor:1, dom:marketing, subscription:media, act:read, allow"},
+			added:       []string{"role:editor:1, dom:marketing, obj:news_tag, act:read, allow"},
+		},
+		{
+			name: "a revoke wins over a grant covering it",
+			permissions: []Permission{{Name: "news", Actions: []Action{
+				{Name: "create", Status: true},
+				{Name: "create_limited", Status: false},
+			}}},
+			removed: []string{},
+			added:   []string{},
+		},
+		{
+			name:        "inherited statuses are left alone",
+			rules:       "p, role:editor:1, dom:marketing, obj:news, act:read, allow",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: false, Inherited: true}}}},
+			removed:     []string{},
+			added:       []string{},
+		},
+		{
+			name:        "other roles and domains are left alone",
+			rules:       "p, role:editor:1, dom:sales, obj:news, act:read, allow\np, role:editor:2, dom:marketing, obj:news, act:read, allow",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "read", Status: false}}}},
+			removed:     []string{},
+			added:       []string{},
+		},
+		{
+			name:        "unknown object",
+			permissions: []Permission{{Name: "invoice", Actions: []Action{{Name: "read", Status: true}}}},
+			err:         ErrInvalidRequest,
+		},
+		{
+			name:        "unknown action",
+			permissions: []Permission{{Name: "news", Actions: []Action{{Name: "publish", Status: true}}}},
+			err:         ErrInvalidRequest,
+		},
+		{
+			name:        "unknown bundle",
+			permissions: []Permission{{Name: "subscription:media", Actions: []Action{{Name: "read", Status: true}}}},
+			err:         ErrInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := openTestPolicy(t, tt.rules)
+			delta, err := diffRolePermissions(e, role, dom, tt.permissions)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := deltaLines(delta.removed); strings.Join(got, "\n") != strings.Join(tt.removed, "\n") {
+				t.Errorf("removed:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.removed, "\n"))
+			}
+			if got := deltaLines(delta.added); strings.Join(got, "\n") != strings.Join(tt.added, "\n") {
+				t.Errorf("added:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.added, "\n"))
+			}
+		})
+	}
+}
+
+func TestUpdateRolePermissions(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:admin:0, dom:marketing, obj:news, act:all, allow
+g, user:jason, role:root:0, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+`)
+	repo := openTestRepository(t)
+	ctx := context.Background()
+	marketing := &Division{Name: "marketing"}
+	permissions := []Permission{{Name: "news", Actions: []Action{
+		{Name: "read", Status: true},
+		{Name: "delete", Status: false},
+	}}}
+
+	matrix, err := UpdateRolePermissions(ctx, e, repo, "user:ian", DivisionRole{Division: marketing, Name: "editor", Level: 1}, permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, permission := range matrix {
+		for _, action := range permission.Actions {
+			if want := permission.Name == "news" && action.Name == "read"; action.Status != want {
+				t.Errorf("%s/%s = %v, want %v", permission.Name, action.Name, action.Status, want)
+			}
+		}
+	}
+	if got := deltaLines(e.GetFilteredPolicy(0, "role:editor:1")); strings.Join(got, "\n") != "role:editor:1, dom:marketing, obj:news, act:read, allow" {
+		t.Errorf("rules of role:editor:1:\n%s", strings.Join(got, "\n"))
+	}
+
+	tests := []struct {
+		name  string
+		actor string
+		role  DivisionRole
+		err   error
+	}{
+		{"no division", "user:jason", DivisionRole{Name: "editor", Level: 1}, ErrInvalidRequest},
+		{"root in dom:Company", "user:jason", DivisionRole{Division: &Division{Name: "Company"}, Name: "root", Level: 0}, ErrInvalidRequest},
+		{"the actor's own level", "user:ian", DivisionRole{Division: marketing, Name: "admin", Level: 0}, ErrDelegationDenied},
+	}
+	for _, tt := range tests {
+		before := deltaLines(e.GetPolicy())
+		if _, err := UpdateRolePermissions(ctx, e, repo, tt.actor, tt.role, permissions); errors.Cause(err) != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if after := deltaLines(e.GetPolicy()); strings.Join(after, "\n") != strings.Join(before, "\n") {
+			t.Errorf("%s: the policy changed", tt.name)
+		}
+	}
+}
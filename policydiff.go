@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PermissionChange is one effective permission gained or lost.
+type PermissionChange struct {
+	Dom string `json:"dom"`
+	Obj string `json:"obj"`
+	Act string `json:"act"`
+}
+
+type SubjectDiff struct {
+	Subject string             `json:"subject"`
+	Gained  []PermissionChange `json:"gained"`
+	Lost    []PermissionChange `json:"lost"`
+}
+
+// PolicyDiff lists, per user and per role, the effective permissions that
+// differ between two policies.
+type PolicyDiff struct {
+	Users []SubjectDiff `json:"users"`
+	Roles []SubjectDiff `json:"roles"`
+}
+
+func (d *PolicyDiff) Empty() bool {
+	return len(d.Users)+len(d.Roles) == 0
+}
+
+// DiffPolicies compares what every user and role may do in every domain under
+// before and after, as each enforcer decides it. Subjects are sorted by name,
+// and changes by domain and then in vocabulary order.
+func DiffPolicies(before *Enforcer, after *Enforcer) (*PolicyDiff, error) {
+	subjects := subjectDomains(before)
+	for sub, doms := range subjectDomains(after) {
+		if _, ok := subjects[sub]; !ok {
+			subjects[sub] = make(map[string]bool)
+		}
+		for dom := range doms {
+			subjects[sub][dom] = true
+		}
+	}
+
+	names := make([]string, 0, len(subjects))
+	for sub := range subjects {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+
+	diff := &PolicyDiff{
+		Users: []SubjectDiff{},
+		Roles: []SubjectDiff{},
+	}
+	for _, sub := range names {
+		doms := make([]string, 0, len(subjects[sub]))
+		for dom := range subjects[sub] {
+			doms = append(doms, dom)
+		}
+		sort.Strings(doms)
+
+		subjectDiff := SubjectDiff{
+			Subject: sub,
+			Gained:  []PermissionChange{},
+			Lost:    []PermissionChange{},
+		}
+		for _, dom := range doms {
+			was, err := effectivePermissions(before, sub, dom)
+			if err != nil {
+				return nil, errors.Wrap(err, "before")
+			}
+			is, err := effectivePermissions(after, sub, dom)
+			if err != nil {
+				return nil, errors.Wrap(err, "after")
+			}
+			for _, obj := range vocabulary.Objects() {
+				for _, act := range vocabulary.Actions() {
+					change := PermissionChange{Dom: dom, Obj: obj, Act: act}
+					switch {
+					case is[obj][act] && !was[obj][act]:
+						subjectDiff.Gained = append(subjectDiff.Gained, change)
+					case was[obj][act] && !is[obj][act]:
+						subjectDiff.Lost = append(subjectDiff.Lost, change)
+					}
+				}
+			}
+		}
+		if len(subjectDiff.Gained)+len(subjectDiff.Lost) == 0 {
+			continue
+		}
+		if strings.HasPrefix(sub, RolePrefix) {
+			diff.Roles = append(diff.Roles, subjectDiff)
+		} else {
+			diff.Users = append(diff.Users, subjectDiff)
+		}
+	}
+	return diff, nil
+}
+
+// subjectDomains returns the domains each subject of p and g rules appears in.
+func subjectDomains(e *Enforcer) map[string]map[string]bool {
+	subjects := make(map[string]map[string]bool)
+	add := func(sub string, dom string) {
+		if _, ok := subjects[sub]; !ok {
+			subjects[sub] = make(map[string]bool)
+		}
+		subjects[sub][dom] = true
+	}
+	for _, p := range e.GetPolicy() {
+		add(p[0], p[1])
+	}
+	for _, g := range e.GetNamedGroupingPolicy("g") {
+		add(g[0], g[2])
+		add(g[1], g[2])
+	}
+	return subjects
+}
+
+// effectivePermissions asks e about every object and action of the
+// vocabulary for sub in dom.
+func effectivePermissions(e *Enforcer, sub string, dom string) (map[string]map[string]bool, error) {
+	mPermissions := make(map[string]map[string]bool)
+	for _, obj := range vocabulary.Objects() {
+		mPermissions[obj] = make(map[string]bool)
+		for _, act := range vocabulary.Actions() {
+			ok, err := e.Enforce(sub, dom, obj, act)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("Enforce(%s, %s, %s, %s)", sub, dom, obj, act))
+			}
+			mPermissions[obj][act] = ok
+		}
+	}
+	return mPermissions, nil
+}
+
+func writeDiffText(w io.Writer, diff *PolicyDiff) {
+	if diff.Empty() {
+		fmt.Fprintln(w, "No effective permission changes.")
+		return
+	}
+	for _, section := range []struct {
+		title    string
+		subjects []SubjectDiff
+	}{
+		{"Users", diff.Users},
+		{"Roles", diff.Roles},
+	} {
+		if len(section.subjects) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", section.title)
+		for _, subjectDiff := range section.subjects {
+			fmt.Fprintf(w, "  %s\n", subjectDiff.Subject)
+			for _, change := range subjectDiff.Gained {
+				fmt.Fprintf(w, "    + %s %s %s\n", change.Dom, change.Obj, change.Act)
+			}
+			for _, change := range subjectDiff.Lost {
+				fmt.Fprintf(w, "    - %s %s %s\n", change.Dom, change.Obj, change.Act)
+			}
+		}
+	}
+}
+
+// runDiff implements "diff -from <source> -to <source>". It returns 1 when
+// -exit-code is set and the policies differ, and 2 on errors.
+func runDiff(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	opts.register(fs)
+	from := fs.String("from", "", "policy source before the change, -policy by default")
+	to := fs.String("to", "", "policy source after the change")
+	format := fs.String("format", "text", "output format, text or json")
+	exitCode := fs.Bool("exit-code", false, "exit with 1 when effective permissions differ")
+	fs.Parse(args)
+
+	if *to == "" {
+		return fail("diff", errors.Wrap(ErrInvalidRequest, "-to is required"))
+	}
+	if *from == "" {
+		*from = opts.policy
+	}
+	if err := opts.loadVocabulary(); err != nil {
+		return fail("diff", err)
+	}
+	before, err := openPolicySource(opts.modelPath, *from)
+	if err != nil {
+		return fail("diff", errors.Wrap(err, fmt.Sprintf("openPolicySource(%s)", *from)))
+	}
+	after, err := openPolicySource(opts.modelPath, *to)
+	if err != nil {
+		return fail("diff", errors.Wrap(err, fmt.Sprintf("openPolicySource(%s)", *to)))
+	}
+
+	diff, err := DiffPolicies(before, after)
+	if err != nil {
+		return fail("diff", err)
+	}
+	switch *format {
+	case "json":
+		if err := printJSON(diff); err != nil {
+			return fail("diff", err)
+		}
+	case "text":
+		writeDiffText(os.Stdout, diff)
+	default:
+		return fail("diff", errors.Wrap(ErrInvalidRequest, fmt.Sprintf("unknown format %q", *format)))
+	}
+
+	if *exitCode && !diff.Empty() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	const base = `
+p, role:editor:1, dom:marketing, obj:news, act:read, allow
+g, user:ian, role:editor:1, dom:marketing
+`
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{
+			name:   "same rules in another order",
+			before: base + "p, role:editor:1, dom:marketing, obj:period, act:read, allow\n",
+			after:  "p, role:editor:1, dom:marketing, obj:period, act:read, allow\n" + base,
+			want:   "No effective permission changes.\n",
+		},
+		{
+			name:   "an action group instead of its actions",
+			before: "p, role:editor:1, dom:marketing, obj:news, act:read, allow\np, role:editor:1, dom:marketing, obj:news, act:create, allow\np, role:editor:1, dom:marketing, obj:news, act:update, allow\np, role:editor:1, dom:marketing, obj:news, act:delete, allow\n",
+			after:  "p, role:editor:1, dom:marketing, obj:news, act:all, allow\n",
+			want:   "No effective permission changes.\n",
+		},
+		{
+			name:   "a role grant reaches its users",
+			before: base,
+			after:  base + "p, role:editor:1, dom:marketing, obj:news, act:create, allow\n",
+			want: `Users:
+  user:ian
+    + dom:marketing obj:news act:create
+    + dom:marketing obj:news act:create_limited
+Roles:
+  role:editor:1
+    + dom:marketing obj:news act:create
+    + dom:marketing obj:news act:create_limited
+`,
+		},
+		{
+			name:   "a user deny",
+			before: base,
+			after:  "p, user:ian, dom:marketing, obj:news, act:read, deny\n" + base,
+			want: `Users:
+  user:ian
+    - dom:marketing obj:news act:read
+`,
+		},
+		{
+			name:   "a revoked role",
+			before: base + "g, user:zoe, role:editor:1, dom:marketing\n",
+			after:  base,
+			want: `Users:
+  user:zoe
+    - dom:marketing obj:news act:read
+`,
+		},
+		{
+			name:   "a new root",
+			before: base,
+			after:  base + "g, user:zoe, role:root:0, dom:Company\n",
+			want:   "Users:\n  user:zoe\n" + allCompanyLines(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffPolicies(openTestPolicy(t, tt.before), openTestPolicy(t, tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			writeDiffText(&b, diff)
+			if b.String() != tt.want {
+				t.Errorf("diff:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+			if diff.Empty() != (tt.want == "No effective permission changes.\n") {
+				t.Errorf("Empty = %v", diff.Empty())
+			}
+		})
+	}
+}
+
+// allCompanyLines lists every object and action of the vocabulary in
+// dom:Company as gained.
+func allCompanyLines() string {
+	var b strings.Builder
+	for _, obj := range vocabulary.Objects() {
+		for _, act := range vocabulary.Actions() {
+			b.WriteString("    + dom:Company " + obj + " " + act + "\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Membership is one user holding one role in one domain, as a g rule.
+type Membership struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+	Dom  string `json:"dom"`
+}
+
+type MembershipMismatch struct {
+	Store  Membership `json:"store"`
+	Policy Membership `json:"policy"`
+}
+
+// ReconcileReport compares the repository, which is the source of truth, with
+// the g rules of the policy.
+type ReconcileReport struct {
+	// Missing memberships are stored but have no g rule.
+	Missing []Membership `json:"missing"`
+	// Extra memberships have a g rule but are not stored.
+	Extra []Membership `json:"extra"`
+	// Mismatched memberships give a user a different role in the same domain.
+	Mismatched []MembershipMismatch `json:"mismatched"`
+	Fixed      bool                 `json:"fixed"`
+}
+
+func (r *ReconcileReport) InSync() bool {
+	return len(r.Missing)+len(r.Extra)+len(r.Mismatched) == 0
+}
+
+// Reconcile reports how the g rules drifted from the stored memberships. With
+// fix set, the g rules are rewritten to match the store in one step through
+// the adapter, and the changes are recorded under actor.
+func Reconcile(ctx context.Context, e *Enforcer, repo Repository, actor string, fix bool) (*ReconcileReport, error) {
+	users, err := repo.ListUsers(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListUsers")
+	}
+
+	var stored []Membership
+	for _, user := range users {
+		for _, divisionRole := range user.DivisionRoles {
+			stored = append(stored, Membership{
+				User: UserPrefix + user.Name,
+				Role: fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level),
+				Dom:  DomPrefix + string(divisionRole.Division.Name),
+			})
+		}
+	}
+	var granted []Membership
+	for _, g := range e.GetNamedGroupingPolicy("g") {
+		if strings.HasPrefix(g[0], UserPrefix) {
+			granted = append(granted, Membership{User: g[0], Role: g[1], Dom: g[2]})
+		}
+	}
+
+	report := diffMemberships(stored, granted)
+	if !fix || report.InSync() {
+		return report, nil
+	}
+
+	delta := policyDelta{ptype: "g"}
+	for _, m := range report.Missing {
+		delta.added = append(delta.added, m.rule())
+	}
+	for _, m := range report.Extra {
+		delta.removed = append(delta.removed, m.rule())
+	}
+	for _, m := range report.Mismatched {
+		delta.added = append(delta.added, m.Store.rule())
+		delta.removed = append(delta.removed, m.Policy.rule())
+	}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return nil, errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return nil, errors.Wrap(err, "recordPolicyDeltas")
+	}
+	report.Fixed = true
+	return report, nil
+}
+
+func diffMemberships(stored []Membership, granted []Membership) *ReconcileReport {
+	report := &ReconcileReport{
+		Missing:    []Membership{},
+		Extra:      []Membership{},
+		Mismatched: []MembershipMismatch{},
+	}
+
+	inStore := make(map[Membership]bool)
+	for _, m := range stored {
+		inStore[m] = true
+	}
+	inPolicy := make(map[Membership]bool)
+	for _, m := range granted {
+		inPolicy[m] = true
+	}
+
+	// Pair leftovers of the same user and domain as mismatches.
+	extraByUserDom := make(map[string][]Membership)
+	var extraOrder []string
+	for _, m := range granted {
+		if inStore[m] {
+			continue
+		}
+		key := m.User + "," + m.Dom
+		if _, ok := extraByUserDom[key]; !ok {
+			extraOrder = append(extraOrder, key)
+		}
+		extraByUserDom[key] = append(extraByUserDom[key], m)
+	}
+	for _, m := range stored {
+		if inPolicy[m] {
+			continue
+		}
+		key := m.User + "," + m.Dom
+		if extras := extraByUserDom[key]; len(extras) > 0 {
+			report.Mismatched = append(report.Mismatched, MembershipMismatch{Store: m, Policy: extras[0]})
+			extraByUserDom[key] = extras[1:]
+			continue
+		}
+		report.Missing = append(report.Missing, m)
+	}
+	for _, key := range extraOrder {
+		report.Extra = append(report.Extra, extraByUserDom[key]...)
+	}
+	return report
+}
+
+func (m Membership) rule() []string {
+	return []string{m.User, m.Role, m.Dom}
+}
+
+// reconcileEvery runs Reconcile on every tick until ctx is done and logs any
+// drift it finds.
+func (s *server) reconcileEvery(ctx context.Context, interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		report, err := Reconcile(ctx, s.e, s.repo, ReconcileActor, fix)
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("Reconcile: %v", err)
+			continue
+		}
+		if !report.InSync() {
+			log.Printf("Reconcile: %d missing, %d extra, %d mismatched g rules, fixed: %t",
+				len(report.Missing), len(report.Extra), len(report.Mismatched), report.Fixed)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiffMemberships(t *testing.T) {
+	m := func(user string, role string, dom string) Membership {
+		return Membership{User: "user:" + user, Role: role, Dom: dom}
+	}
+	tests := []struct {
+		name       string
+		stored     []Membership
+		granted    []Membership
+		missing    string
+		extra      string
+		mismatched string
+	}{
+		{
+			name:    "in sync",
+			stored:  []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			granted: []Membership{m("ian", "role:admin:0", "dom:marketing")},
+		},
+		{
+			name:    "missing",
+			stored:  []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			missing: "[{user:ian role:admin:0 dom:marketing}]",
+		},
+		{
+			name:    "extra",
+			granted: []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			extra:   "[{user:ian role:admin:0 dom:marketing}]",
+		},
+		{
+			name:       "another role in the same domain",
+			stored:     []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			granted:    []Membership{m("ian", "role:editor:2", "dom:marketing")},
+			mismatched: "[{{user:ian role:admin:0 dom:marketing} {user:ian role:editor:2 dom:marketing}}]",
+		},
+		{
+			name:    "the same role in another domain",
+			stored:  []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			granted: []Membership{m("ian", "role:admin:0", "dom:sales")},
+			missing: "[{user:ian role:admin:0 dom:marketing}]",
+			extra:   "[{user:ian role:admin:0 dom:sales}]",
+		},
+		{
+			name:       "leftovers after pairing",
+			stored:     []Membership{m("ian", "role:admin:0", "dom:marketing")},
+			granted:    []Membership{m("ian", "role:editor:2", "dom:marketing"), m("ian", "role:viewer:3", "dom:marketing")},
+			extra:      "[{user:ian role:viewer:3 dom:marketing}]",
+			mismatched: "[{{user:ian role:admin:0 dom:marketing} {user:ian role:editor:2 dom:marketing}}]",
+		},
+	}
+	for _, tt := range tests {
+		report := diffMemberships(tt.stored, tt.granted)
+		for _, field := range []struct {
+			name string
+			got  string
+			want string
+		}{
+			{"missing", fmt.Sprint(report.Missing), tt.missing},
+			{"extra", fmt.Sprint(report.Extra), tt.extra},
+			{"mismatched", fmt.Sprint(report.Mismatched), tt.mismatched},
+		} {
+			if field.want == "" {
+				field.want = "[]"
+			}
+			if field.got != field.want {
+				t.Errorf("%s: %s = %s, want %s", tt.name, field.name, field.got, field.want)
+			}
+		}
+		if want := tt.missing+tt.extra+tt.mismatched == ""; report.InSync() != want {
+			t.Errorf("%s: InSync = %v, want %v", tt.name, report.InSync(), want)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	// The store is the seeded repository: sonnie2 holds admin_member:2 and ian
+	// also holds admin:0 in marketing.
+	e := openTestPolicy(t, `
+p, role:admin:1, dom:Company, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:sonnie, role:admin:1, dom:Company
+g, user:sonnie2, role:admin:1, dom:Company
+g, user:ian, role:admin:1, dom:Company
+g, user:ian2, role:admin_leader:1, dom:marketing
+g, user:vancer, role:organiser:0, dom:Guest
+g, user:ghost, role:admin:1, dom:Company
+g, role:admin:1, role:admin_member:2, dom:Company
+`)
+	repo := openTestRepository(t)
+	ctx := context.Background()
+
+	report, err := Reconcile(ctx, e, repo, ReconcileActor, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "missing [{user:ian role:admin:0 dom:marketing}] " +
+		"extra [{user:ghost role:admin:1 dom:Company}] " +
+		"mismatched [{{user:sonnie2 role:admin_member:2 dom:Company} {user:sonnie2 role:admin:1 dom:Company}}] fixed false"
+	if got := fmt.Sprintf("missing %v extra %v mismatched %v fixed %v", report.Missing, report.Extra, report.Mismatched, report.Fixed); got != want {
+		t.Errorf("report:\n%s\nwant:\n%s", got, want)
+	}
+	if len(e.GetNamedGroupingPolicy("g")) != 8 {
+		t.Error("reporting changed the policy")
+	}
+
+	if report, err = Reconcile(ctx, e, repo, ReconcileActor, true); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Fixed {
+		t.Error("the drift was not fixed")
+	}
+	if report, err = Reconcile(ctx, e, repo, ReconcileActor, false); err != nil {
+		t.Fatal(err)
+	}
+	if !report.InSync() {
+		t.Errorf("still drifting after the fix: %+v", report)
+	}
+	// Role links are not memberships and are kept.
+	if !e.HasNamedGroupingPolicy("g", "role:admin:1", "role:admin_member:2", "dom:Company") {
+		t.Error("the fix removed a g rule between roles")
+	}
+
+	changes, err := repo.ListPolicyChanges(ctx, PolicyChangeFilter{Actor: ReconcileActor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := changeLines(changes)
+	sort.Strings(lines)
+	want = strings.Join([]string{
+		"system:reconcile add g, user:ian, role:admin:0, dom:marketing",
+		"system:reconcile add g, user:sonnie2, role:admin_member:2, dom:Company",
+		"system:reconcile remove g, user:ghost, role:admin:1, dom:Company",
+		"system:reconcile remove g, user:sonnie2, role:admin:1, dom:Company",
+	}, "\n")
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("recorded changes:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReconcileAuthenticatesActor(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:admin:1, dom:Company, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:sonnie, role:admin:1, dom:Company
+g, user:ghost, role:admin:1, dom:Company
+`)
+	repo := openTestRepository(t)
+	tokens, err := NewActorTokens(map[string]string{
+		"jason-token":  "user:jason",
+		"sonnie-token": "user:sonnie",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newServer(e, repo, nil, tokens.Authenticate).routes()
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"report without credentials", http.MethodGet, "", http.StatusOK},
+		{"fix without credentials", http.MethodPost, "", http.StatusUnauthorized},
+		{"fix with an unknown token", http.MethodPost, "guess", http.StatusUnauthorized},
+		{"fix as a company admin", http.MethodPost, "sonnie-token", http.StatusForbidden},
+		{"fix as root", http.MethodPost, "jason-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/reconcile", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+		if tt.want == http.StatusUnauthorized || tt.want == http.StatusForbidden {
+			if !e.HasNamedGroupingPolicy("g", "user:ghost", "role:admin:1", "dom:Company") {
+				t.Errorf("%s: the drift was fixed", tt.name)
+			}
+		}
+	}
+
+	changes, err := repo.ListPolicyChanges(context.Background(), PolicyChangeFilter{Subject: "user:ghost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(changeLines(changes), "\n"); got != "user:jason remove g, user:ghost, role:admin:1, dom:Company" {
+		t.Errorf("recorded changes for user:ghost:\n%s", got)
+	}
+}
@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"casbin-playground/db"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	ObjPrefix = "obj:"
+	ActPrefix = "act:"
+
+	KindObject = "object"
+	KindAction = "action"
+)
+
+// Entry is one object or action, named without its "obj:" or "act:" prefix.
+type Entry struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Config lists objects and actions in display order.
+type Config struct {
+	Objects []Entry `json:"objects"`
+	Actions []Entry `json:"actions"`
+}
+
+// Registry is the vocabulary of objects and actions that policies and
+// permission matrices are built from. Every list it returns keeps the
+// configured display order.
+type Registry struct {
+	objects []Entry
+	actions []Entry
+
+	objectIndex map[string]int
+	actionIndex map[string]int
+}
+
+func Default() *Registry {
+	r, err := New(Config{
+		Objects: []Entry{
+			{Name: "account"},
+			{Name: "location"},
+			{Name: "organiser"},
+			{Name: "period"},
+			{Name: "exhibition"},
+			{Name: "news_tag"},
+			{Name: "news"},
+			{Name: "request_form"},
+		},
+		Actions: []Entry{
+			{Name: "read"},
+			{Name: "create"},
+			{Name: "update"},
+			{Name: "delete"},
+			{Name: "create_limited"},
+			{Name: "update_limited"},
+			{Name: "delete_limited"},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func New(cfg Config) (*Registry, error) {
+	objectIndex, err := indexEntries(KindObject, cfg.Objects)
+	if err != nil {
+		return nil, err
+	}
+	actionIndex, err := indexEntries(KindAction, cfg.Actions)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		objects:     cfg.Objects,
+		actions:     cfg.Actions,
+		objectIndex: objectIndex,
+		actionIndex: actionIndex,
+	}, nil
+}
+
+// Load reads a registry from a JSON file shaped like Config.
+func Load(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile")
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return New(cfg)
+}
+
+// LoadDB reads a registry from the vocabulary_entries table.
+func LoadDB(gdb *gorm.DB) (*Registry, error) {
+	var records []db.VocabularyEntry
+	if err := gdb.Order("position, id").Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "Find vocabulary entries")
+	}
+
+	var cfg Config
+	for _, record := range records {
+		entry := Entry{Name: record.Name, Description: record.Description}
+		switch record.Kind {
+		case KindObject:
+			cfg.Objects = append(cfg.Objects, entry)
+		case KindAction:
+			cfg.Actions = append(cfg.Actions, entry)
+		default:
+			return nil, errors.Errorf("vocabulary entry %s has unknown kind %q", record.Name, record.Kind)
+		}
+	}
+	return New(cfg)
+}
+
+// SeedDB stores r in the vocabulary_entries table when the table is empty.
+func SeedDB(gdb *gorm.DB, r *Registry) error {
+	var count int64
+	if err := gdb.Model(&db.VocabularyEntry{}).Count(&count).Error; err != nil {
+		return errors.Wrap(err, "Count vocabulary entries")
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var records []db.VocabularyEntry
+	for i, entry := range r.objects {
+		records = append(records, db.VocabularyEntry{Kind: KindObject, Name: entry.Name, Description: entry.Description, Position: i})
+	}
+	for i, entry := range r.actions {
+		records = append(records, db.VocabularyEntry{Kind: KindAction, Name: entry.Name, Description: entry.Description, Position: i})
+	}
+	if err := gdb.Create(&records).Error; err != nil {
+		return errors.Wrap(err, "Create vocabulary entries")
+	}
+	return nil
+}
+
+// Config returns the objects and actions with their descriptions, in display
+// order.
+func (r *Registry) Config() Config {
+	return Config{
+		Objects: append([]Entry(nil), r.objects...),
+		Actions: append([]Entry(nil), r.actions...),
+	}
+}
+
+// Objects returns every object with its "obj:" prefix.
+func (r *Registry) Objects() []string {
+	return names(r.objects, ObjPrefix)
+}
+
+func (r *Registry) TrimmedObjects() []string {
+	return names(r.objects, "")
+}
+
+// Actions returns every action with its "act:" prefix.
+func (r *Registry) Actions() []string {
+	return names(r.actions, ActPrefix)
+}
+
+func (r *Registry) TrimmedActions() []string {
+	return names(r.actions, "")
+}
+
+// HasObject reports whether obj is a registered object with its "obj:"
+// prefix. Policies name objects with the prefix, so "news" is not one.
+func (r *Registry) HasObject(obj string) bool {
+	return hasEntry(r.objectIndex, ObjPrefix, obj)
+}
+
+// HasAction reports whether act is a registered action with its "act:"
+// prefix.
+func (r *Registry) HasAction(act string) bool {
+	return hasEntry(r.actionIndex, ActPrefix, act)
+}
+
+func hasEntry(index map[string]int, prefix string, value string) bool {
+	if !strings.HasPrefix(value, prefix) {
+		return false
+	}
+	_, ok := index[strings.TrimPrefix(value, prefix)]
+	return ok
+}
+
+func indexEntries(kind string, entries []Entry) (map[string]int, error) {
+	if len(entries) == 0 {
+		return nil, errors.Errorf("no %ss", kind)
+	}
+
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		if entry.Name == "" {
+			return nil, errors.Errorf("%s %d has no name", kind, i)
+		}
+		if strings.ContainsAny(entry.Name, ":, \t\n") {
+			return nil, errors.Errorf("%s %q must not contain a prefix, comma or space", kind, entry.Name)
+		}
+		if _, ok := index[entry.Name]; ok {
+			return nil, errors.Errorf("%s %q is listed twice", kind, entry.Name)
+		}
+		index[entry.Name] = i
+	}
+	return index, nil
+}
+
+func names(entries []Entry, prefix string) []string {
+	values := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, prefix+entry.Name)
+	}
+	return values
+}
@@ -0,0 +1,50 @@
+package registry
+
+import "testing"
+
+func TestHas(t *testing.T) {
+	r := Default()
+	tests := []struct {
+		value string
+		has   func(string) bool
+		want  bool
+	}{
+		{"obj:news", r.HasObject, true},
+		{"news", r.HasObject, false},
+		{"obj:new", r.HasObject, false},
+		{"act:news", r.HasObject, false},
+		{"act:read", r.HasAction, true},
+		{"read", r.HasAction, false},
+		{"obj:read", r.HasAction, false},
+		{"act:all", r.HasAction, false},
+	}
+	for _, tt := range tests {
+		if got := tt.has(tt.value); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"valid", Config{Objects: []Entry{{Name: "news", Description: "News posts"}}, Actions: []Entry{{Name: "read"}}}, false},
+		{"no actions", Config{Objects: []Entry{{Name: "news"}}}, true},
+		{"prefixed name", Config{Objects: []Entry{{Name: "obj:news"}}, Actions: []Entry{{Name: "read"}}}, true},
+		{"duplicate", Config{Objects: []Entry{{Name: "news"}, {Name: "news"}}, Actions: []Entry{{Name: "read"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New: err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && r.Config().Objects[0].Description != tt.cfg.Objects[0].Description {
+				t.Errorf("Config() lost the description %q", tt.cfg.Objects[0].Description)
+			}
+		})
+	}
+}
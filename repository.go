@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"casbin-playground/db"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Repository stores users, divisions, division roles and the memberships
+// linking users to division roles. Permissions are not stored here; they
+// come from the policy.
+type Repository interface {
+	ListUsers(ctx context.Context) ([]User, error)
+	GetUser(ctx context.Context, name string) (*User, error)
+	ListDivisions(ctx context.Context) ([]Division, error)
+	GetDivision(ctx context.Context, name DivisionName) (*Division, error)
+	// CreateDivision stores division together with its DivisionRoles.
+	CreateDivision(ctx context.Context, division Division) error
+	// CreateDivisionRole stores divisionRole in its Division, which must exist.
+	// It is a no-op when the role is already stored.
+	CreateDivisionRole(ctx context.Context, divisionRole DivisionRole) error
+	// AddUserDivisionRole stores the user on first use, and the role when its
+	// division exists but the role does not yet.
+	AddUserDivisionRole(ctx context.Context, userName string, divisionRole DivisionRole) error
+	RemoveUserDivisionRole(ctx context.Context, userName string, divisionRole DivisionRole) error
+	// DeleteUser removes the user together with its memberships.
+	DeleteUser(ctx context.Context, userName string) error
+	// RecordPolicyChanges appends changes to the audit trail in one step.
+	RecordPolicyChanges(ctx context.Context, changes []PolicyChange) error
+	// ListPolicyChanges returns the recorded changes matching filter, newest
+	// first.
+	ListPolicyChanges(ctx context.Context, filter PolicyChangeFilter) ([]PolicyChange, error)
+}
+
+type gormRepository struct {
+	db *gorm.DB
+}
+
+func newGormRepository(gdb *gorm.DB) (*gormRepository, error) {
+	if err := db.Migrate(gdb); err != nil {
+		return nil, errors.Wrap(err, "db.Migrate")
+	}
+	return &gormRepository{db: gdb}, nil
+}
+
+func (r *gormRepository) ListUsers(ctx context.Context) ([]User, error) {
+	var records []db.User
+	if err := r.usersQuery(ctx).Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "Find users")
+	}
+
+	users := make([]User, 0, len(records))
+	for _, record := range records {
+		users = append(users, userFromRecord(record))
+	}
+	return users, nil
+}
+
+func (r *gormRepository) GetUser(ctx context.Context, name string) (*User, error) {
+	var record db.User
+	err := r.usersQuery(ctx).Where("name = ?", name).First(&record).Error
+	if errors.Cause(err) == gorm.ErrRecordNotFound {
+		return nil, errors.Wrap(ErrUserNotFound, name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("First user %s", name))
+	}
+
+	user := userFromRecord(record)
+	return &user, nil
+}
+
+func (r *gormRepository) ListDivisions(ctx context.Context) ([]Division, error) {
+	var records []db.Division
+	if err := r.divisionsQuery(ctx).Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "Find divisions")
+	}
+
+	divisions := make([]Division, 0, len(records))
+	for _, record := range records {
+		divisions = append(divisions, divisionFromRecord(record))
+	}
+	return divisions, nil
+}
+
+func (r *gormRepository) GetDivision(ctx context.Context, name DivisionName) (*Division, error) {
+	var record db.Division
+	err := r.divisionsQuery(ctx).Where("name = ?", string(name)).First(&record).Error
+	if errors.Cause(err) == gorm.ErrRecordNotFound {
+		return nil, errors.Wrap(ErrDivisionNotFound, string(name))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("First division %s", name))
+	}
+
+	division := divisionFromRecord(record)
+	return &division, nil
+}
+
+func (r *gormRepository) CreateDivision(ctx context.Context, division Division) error {
+	record := db.Division{
+		Name: string(division.Name),
+		Type: string(division.Type),
+	}
+	for _, divisionRole := range division.DivisionRoles {
+		record.DivisionRoles = append(record.DivisionRoles, db.DivisionRole{
+			Name:  string(divisionRole.Name),
+			Level: divisionRole.Level,
+		})
+	}
+	if err := r.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Create division %s", division.Name))
+	}
+	return nil
+}
+
+func (r *gormRepository) CreateDivisionRole(ctx context.Context, divisionRole DivisionRole) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := findOrCreateDivisionRole(tx, divisionRole)
+		return err
+	})
+}
+
+func (r *gormRepository) AddUserDivisionRole(ctx context.Context, userName string, divisionRole DivisionRole) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user := db.User{Name: userName}
+		if err := tx.Where(&user).FirstOrCreate(&user).Error; err != nil {
+			return errors.Wrap(err, fmt.Sprintf("FirstOrCreate user %s", userName))
+		}
+		roleRecord, err := findOrCreateDivisionRole(tx, divisionRole)
+		if err != nil {
+			return err
+		}
+
+		membership := db.UserDivisionRole{UserID: user.ID, DivisionRoleID: roleRecord.ID}
+		if err := tx.Where(&membership).FirstOrCreate(&membership).Error; err != nil {
+			return errors.Wrap(err, fmt.Sprintf("FirstOrCreate membership of %s", userName))
+		}
+		return nil
+	})
+}
+
+func (r *gormRepository) RemoveUserDivisionRole(ctx context.Context, userName string, divisionRole DivisionRole) error {
+	if divisionRole.Division == nil {
+		return errors.Wrap(ErrInvalidRequest, "division is required")
+	}
+	subQuery := r.db.Model(&db.DivisionRole{}).
+		Select("division_roles.id").
+		Joins("JOIN divisions ON divisions.id = division_roles.division_id").
+		Where("divisions.name = ? AND division_roles.name = ? AND division_roles.level = ?",
+			string(divisionRole.Division.Name), string(divisionRole.Name), divisionRole.Level)
+	userQuery := r.db.Model(&db.User{}).Select("id").Where("name = ?", userName)
+
+	err := r.db.WithContext(ctx).
+		Where("user_id IN (?) AND division_role_id IN (?)", userQuery, subQuery).
+		Delete(&db.UserDivisionRole{}).Error
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Delete membership of %s", userName))
+	}
+	return nil
+}
+
+func (r *gormRepository) DeleteUser(ctx context.Context, userName string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user db.User
+		err := tx.Where("name = ?", userName).First(&user).Error
+		if errors.Cause(err) == gorm.ErrRecordNotFound {
+			return errors.Wrap(ErrUserNotFound, userName)
+		}
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("First user %s", userName))
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&db.UserDivisionRole{}).Error; err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Delete memberships of %s", userName))
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Delete user %s", userName))
+		}
+		return nil
+	})
+}
+
+func (r *gormRepository) RecordPolicyChanges(ctx context.Context, changes []PolicyChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	records := make([]db.PolicyAudit, 0, len(changes))
+	for _, change := range changes {
+		record, err := policyChangeRecord(change)
+		if err != nil {
+			return errors.Wrap(err, "policyChangeRecord")
+		}
+		records = append(records, record)
+	}
+	if err := r.db.WithContext(ctx).Create(&records).Error; err != nil {
+		return errors.Wrap(err, "Create policy audits")
+	}
+	return nil
+}
+
+func (r *gormRepository) ListPolicyChanges(ctx context.Context, filter PolicyChangeFilter) ([]PolicyChange, error) {
+	query := r.db.WithContext(ctx).Order("created_at DESC, id DESC")
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Subject != "" {
+		query = query.Where("subject = ?", filter.Subject)
+	}
+	if filter.Dom != "" {
+		query = query.Where("dom = ?", filter.Dom)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var records []db.PolicyAudit
+	if err := query.Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "Find policy audits")
+	}
+	changes := make([]PolicyChange, 0, len(records))
+	for _, record := range records {
+		change, err := policyChangeFromRecord(record)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("policyChangeFromRecord(%d)", record.ID))
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (r *gormRepository) usersQuery(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).
+		Preload("UserDivisionRoles.DivisionRole.Division").
+		Order("id")
+}
+
+func (r *gormRepository) divisionsQuery(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).
+		Preload("DivisionRoles", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("level, id")
+		}).
+		Order("id")
+}
+
+func findOrCreateDivisionRole(tx *gorm.DB, divisionRole DivisionRole) (*db.DivisionRole, error) {
+	if divisionRole.Division == nil {
+		return nil, errors.Wrap(ErrInvalidRequest, "division is required")
+	}
+
+	var division db.Division
+	err := tx.Where("name = ?", string(divisionRole.Division.Name)).First(&division).Error
+	if errors.Cause(err) == gorm.ErrRecordNotFound {
+		return nil, errors.Wrap(ErrDivisionNotFound, string(divisionRole.Division.Name))
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("First division %s", divisionRole.Division.Name))
+	}
+
+	record := db.DivisionRole{
+		DivisionID: division.ID,
+		Name:       string(divisionRole.Name),
+		Level:      divisionRole.Level,
+	}
+	// A map keeps level 0 in the condition; struct conditions skip zero values.
+	conds := map[string]interface{}{
+		"division_id": record.DivisionID,
+		"name":        record.Name,
+		"level":       record.Level,
+	}
+	if err := tx.Where(conds).FirstOrCreate(&record).Error; err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("FirstOrCreate division role %s:%d", divisionRole.Name, divisionRole.Level))
+	}
+	return &record, nil
+}
+
+func userFromRecord(record db.User) User {
+	user := User{
+		Name:          record.Name,
+		DivisionRoles: []DivisionRole{},
+	}
+	for _, membership := range record.UserDivisionRoles {
+		user.DivisionRoles = append(user.DivisionRoles, DivisionRole{
+			Division: &Division{
+				Name: DivisionName(membership.DivisionRole.Division.Name),
+				Type: DivisionType(membership.DivisionRole.Division.Type),
+			},
+			Name:  DivisionRoleName(membership.DivisionRole.Name),
+			Level: membership.DivisionRole.Level,
+		})
+	}
+	return user
+}
+
+func policyChangeRecord(change PolicyChange) (db.PolicyAudit, error) {
+	before, err := encodeRule(change.Before)
+	if err != nil {
+		return db.PolicyAudit{}, err
+	}
+	after, err := encodeRule(change.After)
+	if err != nil {
+		return db.PolicyAudit{}, err
+	}
+	return db.PolicyAudit{
+		Actor:     change.Actor,
+		Op:        string(change.Op),
+		Ptype:     change.Ptype,
+		Subject:   change.Subject,
+		Dom:       change.Dom,
+		Before:    before,
+		After:     after,
+		Reason:    change.Reason,
+		CreatedAt: change.Time,
+	}, nil
+}
+
+func policyChangeFromRecord(record db.PolicyAudit) (PolicyChange, error) {
+	before, err := decodeRule(record.Before)
+	if err != nil {
+		return PolicyChange{}, err
+	}
+	after, err := decodeRule(record.After)
+	if err != nil {
+		return PolicyChange{}, err
+	}
+	return PolicyChange{
+		ID:      record.ID,
+		Time:    record.CreatedAt,
+		Actor:   record.Actor,
+		Op:      PolicyChangeOp(record.Op),
+		Ptype:   record.Ptype,
+		Subject: record.Subject,
+		Dom:     record.Dom,
+		Before:  before,
+		After:   after,
+		Reason:  record.Reason,
+	}, nil
+}
+
+func divisionFromRecord(record db.Division) Division {
+	division := Division{
+		Name:          DivisionName(record.Name),
+		Type:          DivisionType(record.Type),
+		DivisionRoles: []DivisionRole{},
+	}
+	for _, divisionRole := range record.DivisionRoles {
+		division.DivisionRoles = append(division.DivisionRoles, DivisionRole{
+			Name:  DivisionRoleName(divisionRole.Name),
+			Level: divisionRole.Level,
+		})
+	}
+	return division
+}
+
+// seedRepository fills an empty repository with the users and divisions the
+// playground policy was written for.
+func seedRepository(ctx context.Context, repo Repository) error {
+	divisions, err := repo.ListDivisions(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ListDivisions")
+	}
+	if len(divisions) > 0 {
+		return nil
+	}
+
+	for _, division := range seedDivisions() {
+		if err := repo.CreateDivision(ctx, division); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("CreateDivision(%s)", division.Name))
+		}
+	}
+	for _, user := range seedUsers() {
+		for _, divisionRole := range user.DivisionRoles {
+			if err := repo.AddUserDivisionRole(ctx, user.Name, divisionRole); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("AddUserDivisionRole(%s)", user.Name))
+			}
+		}
+	}
+	return nil
+}
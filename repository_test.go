@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"casbin-playground/db"
+
+	"github.com/pkg/errors"
+)
+
+// openTestRepository returns a repository on a new SQLite database, seeded
+// like an empty playground database.
+func openTestRepository(t *testing.T) *gormRepository {
+	t.Helper()
+	gdb, err := db.OpenSQLite(filepath.Join(t.TempDir(), "playground.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := newGormRepository(gdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seedRepository(context.Background(), repo); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// roleNames lists divisionRoles as "dom:name:level".
+func roleNames(divisionRoles []DivisionRole) []string {
+	names := []string{}
+	for _, divisionRole := range divisionRoles {
+		division := ""
+		if divisionRole.Division != nil {
+			division = string(divisionRole.Division.Name) + ":"
+		}
+		names = append(names, fmt.Sprintf("%s%s:%d", division, divisionRole.Name, divisionRole.Level))
+	}
+	return names
+}
+
+func TestRepositorySeed(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	// Seeding a seeded repository adds nothing.
+	if err := seedRepository(ctx, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := repo.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(seedUsers()) {
+		t.Fatalf("ListUsers returned %d users, want %d", len(users), len(seedUsers()))
+	}
+	for i, want := range seedUsers() {
+		if users[i].Name != want.Name || fmt.Sprint(roleNames(users[i].DivisionRoles)) != fmt.Sprint(roleNames(want.DivisionRoles)) {
+			t.Errorf("user %d = %s %v, want %s %v", i, users[i].Name, roleNames(users[i].DivisionRoles), want.Name, roleNames(want.DivisionRoles))
+		}
+	}
+
+	divisions, err := repo.ListDivisions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range seedDivisions() {
+		if divisions[i].Name != want.Name || divisions[i].Type != want.Type ||
+			fmt.Sprint(roleNames(divisions[i].DivisionRoles)) != fmt.Sprint(roleNames(want.DivisionRoles)) {
+			t.Errorf("division %d = %+v, want %+v", i, divisions[i], want)
+		}
+	}
+}
+
+func TestRepositoryNotFound(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+
+	if _, err := repo.GetUser(ctx, "nobody"); errors.Cause(err) != ErrUserNotFound {
+		t.Errorf("GetUser: err = %v, want %v", err, ErrUserNotFound)
+	}
+	if _, err := repo.GetDivision(ctx, "nowhere"); errors.Cause(err) != ErrDivisionNotFound {
+		t.Errorf("GetDivision: err = %v, want %v", err, ErrDivisionNotFound)
+	}
+	divisionRole := DivisionRole{Division: &Division{Name: "nowhere"}, Name: "admin", Level: 0}
+	if err := repo.AddUserDivisionRole(ctx, "ian", divisionRole); errors.Cause(err) != ErrDivisionNotFound {
+		t.Errorf("AddUserDivisionRole: err = %v, want %v", err, ErrDivisionNotFound)
+	}
+}
+
+func TestRepositoryMemberships(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestRepository(t)
+	marketing := &Division{Name: "marketing"}
+
+	steps := []struct {
+		name string
+		run  func() error
+		want []string
+	}{
+		{
+			name: "add a new user to a new role",
+			run: func() error {
+				return repo.AddUserDivisionRole(ctx, "zoe", DivisionRole{Division: marketing, Name: "editor", Level: 2})
+			},
+			want: []string{"marketing:editor:2"},
+		},
+		{
+			name: "add the same membership again",
+			run: func() error {
+				return repo.AddUserDivisionRole(ctx, "zoe", DivisionRole{Division: marketing, Name: "editor", Level: 2})
+			},
+			want: []string{"marketing:editor:2"},
+		},
+		{
+			name: "add a level 0 role",
+			run: func() error {
+				return repo.AddUserDivisionRole(ctx, "zoe", DivisionRole{Division: marketing, Name: "admin", Level: 0})
+			},
+			want: []string{"marketing:admin:0", "marketing:editor:2"},
+		},
+		{
+			name: "remove a membership",
+			run: func() error {
+				return repo.RemoveUserDivisionRole(ctx, "zoe", DivisionRole{Division: marketing, Name: "editor", Level: 2})
+			},
+			want: []string{"marketing:admin:0"},
+		},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		user, err := repo.GetUser(ctx, "zoe")
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := roleNames(user.DivisionRoles); fmt.Sprint(got) != fmt.Sprint(step.want) {
+			t.Errorf("%s: roles = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	division, err := repo.GetDivision(ctx, "marketing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"admin:0", "admin_leader:1", "editor:2"}
+	if got := roleNames(division.DivisionRoles); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("marketing roles = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDomainNotFound      = errors.New("domain not found")
+	ErrRoleNotFound        = errors.New("role not found")
+	ErrRoleAlreadyAssigned = errors.New("role already assigned")
+	ErrRoleNotAssigned     = errors.New("role not assigned")
+)
+
+func ListUserDivisionRoles(ctx context.Context, e *Enforcer, repo Repository, userName string) ([]DivisionRole, error) {
+	if userName == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty user name")
+	}
+
+	divisions, err := repo.ListDivisions(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDivisions")
+	}
+	divisionTypes := make(map[DivisionName]DivisionType)
+	for _, division := range divisions {
+		divisionTypes[division.Name] = division.Type
+	}
+
+	divisionRoles := []DivisionRole{}
+	for _, g := range e.GetFilteredNamedGroupingPolicy("g", 0, UserPrefix+userName) {
+		name, level, err := parseRole(g[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("parseRole(%s)", g[1]))
+		}
+		divisionName := DivisionName(strings.TrimPrefix(g[2], DomPrefix))
+		divisionRoles = append(divisionRoles, DivisionRole{
+			Division: &Division{
+				Name: divisionName,
+				Type: divisionTypes[divisionName],
+			},
+			Name:  name,
+			Level: level,
+		})
+	}
+	return divisionRoles, nil
+}
+
+func AssignDivisionRole(ctx context.Context, e *Enforcer, repo Repository, actor string, userName string, divisionRole DivisionRole) error {
+	rule, err := buildRoleAssignment(e, actor, userName, divisionRole)
+	if err != nil {
+		return errors.Wrap(err, "buildRoleAssignment")
+	}
+	if e.HasNamedGroupingPolicy("g", rule) {
+		return errors.Wrap(ErrRoleAlreadyAssigned, strings.Join(rule, ", "))
+	}
+
+	delta := policyDelta{ptype: "g", added: [][]string{rule}}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.AddUserDivisionRole(ctx, userName, divisionRole); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "AddUserDivisionRole"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return errors.Wrap(err, "recordPolicyDeltas")
+	}
+	return nil
+}
+
+func RevokeDivisionRole(ctx context.Context, e *Enforcer, repo Repository, actor string, userName string, divisionRole DivisionRole) error {
+	rule, err := buildRoleAssignment(e, actor, userName, divisionRole)
+	if err != nil {
+		return errors.Wrap(err, "buildRoleAssignment")
+	}
+	if !e.HasNamedGroupingPolicy("g", rule) {
+		return errors.Wrap(ErrRoleNotAssigned, strings.Join(rule, ", "))
+	}
+
+	delta := policyDelta{ptype: "g", removed: [][]string{rule}}
+	if err := applyPolicyDeltas(e, delta); err != nil {
+		return errors.Wrap(err, "applyPolicyDeltas")
+	}
+	if err := repo.RemoveUserDivisionRole(ctx, userName, divisionRole); err != nil {
+		return revertPolicyDeltas(e, errors.Wrap(err, "RemoveUserDivisionRole"), delta)
+	}
+	if err := recordPolicyDeltas(ctx, e, repo, actor, delta); err != nil {
+		return errors.Wrap(err, "recordPolicyDeltas")
+	}
+	return nil
+}
+
+// buildRoleAssignment returns the g rule linking userName to divisionRole,
+// after checking that both the domain and the role are known to the policy
+// and that actor may delegate the role.
+func buildRoleAssignment(e *Enforcer, actor string, userName string, divisionRole DivisionRole) ([]string, error) {
+	if userName == "" {
+		return nil, errors.Wrap(ErrInvalidRequest, "empty user name")
+	}
+	if divisionRole.Division == nil {
+		return nil, errors.Wrap(ErrInvalidRequest, "division is required")
+	}
+	role := fmt.Sprintf(RolePrefixFormat, divisionRole.Name, divisionRole.Level)
+	dom := DomPrefix + string(divisionRole.Division.Name)
+
+	if !domainExists(e, dom) {
+		return nil, errors.Wrap(ErrDomainNotFound, dom)
+	}
+	if !roleExists(e, role, dom) {
+		return nil, errors.Wrap(ErrRoleNotFound, fmt.Sprintf("%s in %s", role, dom))
+	}
+	if err := checkDelegation(e, actor, role, dom); err != nil {
+		return nil, errors.Wrap(err, "checkDelegation")
+	}
+	return []string{UserPrefix + userName, role, dom}, nil
+}
+
+func domainExists(e *Enforcer, dom string) bool {
+	return len(e.GetFilteredPolicy(1, dom)) > 0 ||
+		len(e.GetFilteredNamedGroupingPolicy("g", 2, dom)) > 0
+}
+
+// roleExists reports whether role is granted anything or held by anyone in
+// dom. Root in the company domain exists through the matcher alone.
+func roleExists(e *Enforcer, role string, dom string) bool {
+	if role == string(RootRole) && dom == string(CompanyDom) {
+		return true
+	}
+	return len(e.GetFilteredPolicy(0, role, dom)) > 0 ||
+		len(e.GetFilteredNamedGroupingPolicy("g", 1, role, dom)) > 0
+}
+
+// parseRole splits a "role:name:level" subject into its name and level.
+func parseRole(role string) (DivisionRoleName, int, error) {
+	rest := strings.TrimPrefix(role, RolePrefix)
+	i := strings.LastIndex(rest, ":")
+	if rest == role || i <= 0 {
+		return "", 0, errors.Errorf("%q is not of the form role:name:level", role)
+	}
+	level, err := strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return "", 0, errors.Wrap(err, fmt.Sprintf("%q has no numeric level", role))
+	}
+	return DivisionRoleName(rest[:i]), level, nil
+}
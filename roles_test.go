@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDivisionRoleAssignment(t *testing.T) {
+	e := openTestPolicy(t, `
+p, role:admin:0, dom:marketing, obj:news, act:all, allow
+p, role:editor:2, dom:marketing, obj:news, act:read, allow
+g, user:jason, role:root:0, dom:Company
+g, user:ian, role:admin:0, dom:marketing
+`)
+	repo := openTestRepository(t)
+	ctx := context.Background()
+	role := func(division DivisionName, name DivisionRoleName, level int) DivisionRole {
+		return DivisionRole{Division: &Division{Name: division}, Name: name, Level: level}
+	}
+
+	steps := []struct {
+		name   string
+		revoke bool
+		user   string
+		role   DivisionRole
+		err    error
+	}{
+		{"assign", false, "zoe", role("marketing", "editor", 2), nil},
+		{"assign twice", false, "zoe", role("marketing", "editor", 2), ErrRoleAlreadyAssigned},
+		{"assign root", false, "zoe", role("Company", "root", 0), nil},
+		{"unknown domain", false, "zoe", role("sales", "editor", 2), ErrDomainNotFound},
+		{"unknown role", false, "zoe", role("marketing", "editor", 3), ErrRoleNotFound},
+		{"no division", false, "zoe", DivisionRole{Name: "editor", Level: 2}, ErrInvalidRequest},
+		{"no user", false, "", role("marketing", "editor", 2), ErrInvalidRequest},
+		{"revoke", true, "zoe", role("Company", "root", 0), nil},
+		{"revoke twice", true, "zoe", role("Company", "root", 0), ErrRoleNotAssigned},
+		{"revoke unknown role", true, "zoe", role("marketing", "writer", 2), ErrRoleNotFound},
+	}
+	for _, step := range steps {
+		change := AssignDivisionRole
+		if step.revoke {
+			change = RevokeDivisionRole
+		}
+		if err := change(ctx, e, repo, "user:jason", step.user, step.role); errors.Cause(err) != step.err {
+			t.Errorf("%s: err = %v, want %v", step.name, err, step.err)
+		}
+	}
+
+	if got := deltaLines(e.GetFilteredNamedGroupingPolicy("g", 0, "user:zoe")); strings.Join(got, "\n") != "user:zoe, role:editor:2, dom:marketing" {
+		t.Errorf("g rules of user:zoe:\n%s", strings.Join(got, "\n"))
+	}
+	divisionRoles, err := ListUserDivisionRoles(ctx, e, repo, "zoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(roleNames(divisionRoles)); got != "[marketing:editor:2]" {
+		t.Errorf("ListUserDivisionRoles = %s", got)
+	}
+	if divisionRoles[0].Division.Type != DivisionTypeDivision {
+		t.Errorf("division type = %q, want %q", divisionRoles[0].Division.Type, DivisionTypeDivision)
+	}
+	user, err := repo.GetUser(ctx, "zoe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(roleNames(user.DivisionRoles)); got != "[marketing:editor:2]" {
+		t.Errorf("stored roles of zoe = %s", got)
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		role  string
+		name  DivisionRoleName
+		level int
+		ok    bool
+	}{
+		{"role:admin:0", "admin", 0, true},
+		{"role:admin_member:2", "admin_member", 2, true},
+		{"role:a:b:1", "a:b", 1, true},
+		{"role:admin", "", 0, false},
+		{"role::1", "", 0, false},
+		{"role:admin:x", "", 0, false},
+		{"user:admin:1", "", 0, false},
+	}
+	for _, tt := range tests {
+		name, level, err := parseRole(tt.role)
+		if (err == nil) != tt.ok || name != tt.name || level != tt.level {
+			t.Errorf("parseRole(%q) = %q, %d, %v", tt.role, name, level, err)
+		}
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"casbin-playground/account"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AuditReasonHeader is recorded in the audit trail with every policy
+	// change the request makes.
+	AuditReasonHeader = "X-Audit-Reason"
+)
+
+type server struct {
+	// mu guards e, which is shared by every handler.
+	mu sync.RWMutex
+	e  *Enforcer
+
+	repo     Repository
+	accounts *account.Manager
+	// authenticate tells who performs a change.
+	authenticate Authenticator
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type canManageResponse struct {
+	Allowed bool `json:"allowed"`
+}
+
+func newServer(e *Enforcer, repo Repository, accounts *account.Manager, authenticate Authenticator) *server {
+	return &server{e: e, repo: repo, accounts: accounts, authenticate: authenticate}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/permissions", s.handleListUsersPermission)
+	mux.HandleFunc("/users/permissions/", s.handleGetUserPermission)
+	mux.HandleFunc("/divisions/permissions", s.handleListDivisionsPermission)
+	mux.HandleFunc("/divisions/permissions/", s.handleGetDivisionPermission)
+	mux.HandleFunc("/divisions", s.handleCreateDivision)
+	mux.HandleFunc("/divisions/clone", s.handleCloneDivision)
+	mux.HandleFunc("/divisions/roles/permissions", s.handleUpdateRolePermissions)
+	mux.HandleFunc("/users/roles/", s.handleUserRoles)
+	mux.HandleFunc("/accounts", s.handleCreateAccount)
+	mux.HandleFunc("/accounts/", s.handleAccount)
+	mux.HandleFunc("/accounts/can-manage", s.handleCanManageAccount)
+	mux.HandleFunc("/reconcile", s.handleReconcile)
+	mux.HandleFunc("/enforce/batch", s.handleBatchEnforce)
+	mux.HandleFunc("/enforce/explain", s.handleExplain)
+	mux.HandleFunc("/audit", s.handleListPolicyChanges)
+	mux.HandleFunc("/registry", s.handleRegistry)
+	return mux
+}
+
+func (s *server) handleListUsersPermission(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	s.mu.RLock()
+	users, err := ListUsersPermission(r.Context(), s.e, s.repo)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "ListUsersPermission"))
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *server) handleGetUserPermission(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	name, ok := pathParam(w, r, "/users/permissions/")
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	user, err := GetUserPermission(r.Context(), s.e, s.repo, name)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *server) handleListDivisionsPermission(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	s.mu.RLock()
+	divisions, err := ListDivisionsPermission(r.Context(), s.e, s.repo)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "ListDivisionsPermission"))
+		return
+	}
+	writeJSON(w, http.StatusOK, divisions)
+}
+
+func (s *server) handleGetDivisionPermission(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	name, ok := pathParam(w, r, "/divisions/permissions/")
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	division, err := GetDivisionPermission(r.Context(), s.e, s.repo, DivisionName(name))
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, division)
+}
+
+func (s *server) handleCreateDivision(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	var division Division
+	if !decodeJSON(w, r, &division) {
+		return
+	}
+
+	s.mu.Lock()
+	created, err := CreateDivision(auditContext(r), s.e, s.repo, actor, division)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *server) handleCloneDivision(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	var req CloneDivisionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	result, err := CloneDivision(auditContext(r), s.e, s.repo, actor, req)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleUpdateRolePermissions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPut) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	var divisionRole DivisionRole
+	if !decodeJSON(w, r, &divisionRole) {
+		return
+	}
+
+	s.mu.Lock()
+	permissions, err := UpdateRolePermissions(auditContext(r), s.e, s.repo, actor, divisionRole, divisionRole.Permissions)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	divisionRole.Permissions = permissions
+	writeJSON(w, http.StatusOK, divisionRole)
+}
+
+func (s *server) handleUserRoles(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	name, ok := pathParam(w, r, "/users/roles/")
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		s.mu.RLock()
+		divisionRoles, err := ListUserDivisionRoles(r.Context(), s.e, s.repo, name)
+		s.mu.RUnlock()
+		if err != nil {
+			writeError(w, statusFromError(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, divisionRoles)
+		return
+	}
+
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	var divisionRole DivisionRole
+	if !decodeJSON(w, r, &divisionRole) {
+		return
+	}
+
+	var err error
+	s.mu.Lock()
+	if r.Method == http.MethodPost {
+		err = AssignDivisionRole(auditContext(r), s.e, s.repo, actor, name, divisionRole)
+	} else {
+		err = RevokeDivisionRole(auditContext(r), s.e, s.repo, actor, name, divisionRole)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleCreateAccount(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	var req CreateAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	user, err := CreateAccount(auditContext(r), s.e, s.repo, s.accounts, actor, req)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// handleAccount edits an account on PUT and deletes it on DELETE.
+func (s *server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	name, ok := pathParam(w, r, "/accounts/")
+	if !ok {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodPut {
+		var req UpdateAccountRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		s.mu.Lock()
+		err = UpdateAccount(auditContext(r), s.e, s.repo, s.accounts, actor, name, req)
+		s.mu.Unlock()
+	} else {
+		s.mu.Lock()
+		err = DeleteAccount(auditContext(r), s.e, s.repo, s.accounts, actor, name)
+		s.mu.Unlock()
+	}
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCanManageAccount reports whether the authenticated actor's account
+// tier may manage accounts of the target tier.
+func (s *server) handleCanManageAccount(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	target := account.Tier(r.URL.Query().Get("target"))
+
+	s.mu.RLock()
+	tier, err := actorAccountTier(r.Context(), s.e, s.repo, actor)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	allowed, err := s.accounts.CanManage(tier, target)
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, canManageResponse{Allowed: allowed})
+}
+
+// handleReconcile reports drift on GET and fixes it on POST. Only root in the
+// company domain may fix it, since the fix rewrites memberships of every
+// domain.
+func (s *server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		s.mu.RLock()
+		report, err := Reconcile(r.Context(), s.e, s.repo, "", false)
+		s.mu.RUnlock()
+		if err != nil {
+			writeError(w, statusFromError(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	var report *ReconcileReport
+	err := errors.Wrap(ErrDelegationDenied, fmt.Sprintf("%s is not %s in %s", actor, RootRole, CompanyDom))
+	if isRoot(s.e, actor, string(CompanyDom)) {
+		report, err = Reconcile(auditContext(r), s.e, s.repo, actor, true)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleListPolicyChanges returns the audit trail, newest first, filtered by
+// the actor, subject, dom, since, until and limit query parameters. Only root
+// and the admins of the filtered domain may read it.
+func (s *server) handleListPolicyChanges(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	actor, ok := s.actor(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	s.mu.RLock()
+	err := checkAuditAccess(s.e, actor, query.Get("dom"))
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	filter := PolicyChangeFilter{
+		Actor:   query.Get("actor"),
+		Subject: query.Get("subject"),
+		Dom:     query.Get("dom"),
+		Limit:   100,
+	}
+	if filter.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			writeError(w, http.StatusBadRequest, errors.Errorf("limit %q is not a non-negative number", limit))
+			return
+		}
+	}
+
+	changes, err := s.repo.ListPolicyChanges(r.Context(), filter)
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// handleRegistry lists the objects and actions of the vocabulary with their
+// descriptions, in display order.
+func (s *server) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, vocabulary.Config())
+}
+
+func (s *server) handleBatchEnforce(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req BatchEnforceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.RLock()
+	results, err := BatchEnforce(r.Context(), s.e, req.Sub, req.Checks)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, BatchEnforceResponse{Results: results})
+}
+
+func (s *server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req ExplainRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.RLock()
+	explanation, err := Explain(r.Context(), s.e, req)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// pathParam returns the single path segment following prefix.
+func pathParam(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	param := strings.TrimPrefix(r.URL.Path, prefix)
+	if param == "" || strings.Contains(param, "/") {
+		writeError(w, http.StatusNotFound, errors.Errorf("path %s not found", r.URL.Path))
+		return "", false
+	}
+	return param, true
+}
+
+// actor returns the authenticated subject performing a change, for example
+// "user:ian".
+func (s *server) actor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	actor, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return "", false
+	}
+	return actor, true
+}
+
+func auditContext(r *http.Request) context.Context {
+	return WithAuditReason(r.Context(), r.Header.Get(AuditReasonHeader))
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "json.Decode"))
+		return false
+	}
+	return true
+}
+
+func statusFromError(err error) int {
+	switch errors.Cause(err) {
+	case ErrUserNotFound, ErrDivisionNotFound, ErrRoleNotAssigned:
+		return http.StatusNotFound
+	case ErrInvalidRequest, ErrDomainNotFound, ErrRoleNotFound,
+		ErrUnknownDivisionType, ErrTemplateNotFound, account.ErrInvalidTier:
+		return http.StatusBadRequest
+	case ErrRoleAlreadyAssigned, ErrDivisionExists, ErrCloneConflict, ErrUserExists:
+		return http.StatusConflict
+	case ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case ErrDelegationDenied, ErrAuditForbidden, account.ErrForbidden:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json.Encode: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
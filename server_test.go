@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const permissionRules = `
+p, role:admin:1, dom:Company, obj:account, act:read, allow
+p, role:admin_leader:1, dom:marketing, obj:news, act:read, allow
+p, role:admin_leader:1, dom:marketing, obj:news, act:update, deny
+g, user:sonnie, role:admin:1, dom:Company
+g, user:ian2, role:admin_leader:1, dom:marketing
+`
+
+// grantedActions lists the allowed actions of permissions as "obj:act".
+func grantedActions(permissions []Permission) []string {
+	granted := []string{}
+	for _, permission := range permissions {
+		for _, action := range permission.Actions {
+			if action.Status {
+				granted = append(granted, permission.Name+":"+action.Name)
+			}
+		}
+	}
+	return granted
+}
+
+// jsonKeys returns the sorted keys of the JSON object body.
+func jsonKeys(t *testing.T, body []byte) string {
+	t.Helper()
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+func TestPermissionHandlerStatus(t *testing.T) {
+	handler := newServer(openTestPolicy(t, permissionRules), openTestRepository(t), nil, nil).routes()
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/users/permissions", http.StatusOK},
+		{http.MethodGet, "/users/permissions/ian2", http.StatusOK},
+		{http.MethodGet, "/users/permissions/nobody", http.StatusNotFound},
+		{http.MethodGet, "/users/permissions/ian2/roles", http.StatusNotFound},
+		{http.MethodPost, "/users/permissions", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/users/permissions/ian2", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/divisions/permissions", http.StatusOK},
+		{http.MethodGet, "/divisions/permissions/marketing", http.StatusOK},
+		{http.MethodGet, "/divisions/permissions/sales", http.StatusNotFound},
+		{http.MethodPut, "/divisions/permissions", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/divisions/permissions/marketing", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want == http.StatusMethodNotAllowed && w.Header().Get("Allow") != http.MethodGet {
+			t.Errorf("%s %s: Allow %q, want %q", tt.method, tt.path, w.Header().Get("Allow"), http.MethodGet)
+		}
+		if tt.want != http.StatusOK {
+			if keys := jsonKeys(t, w.Body.Bytes()); keys != "error" {
+				t.Errorf("%s %s: error body has keys %s", tt.method, tt.path, keys)
+			}
+		}
+	}
+}
+
+func TestUserPermissionHandlers(t *testing.T) {
+	handler := newServer(openTestPolicy(t, permissionRules), openTestRepository(t), nil, nil).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/permissions", nil))
+	var users []User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	names := []string{}
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	if got := strings.Join(names, " "); got != "jason sonnie sonnie2 ian ian2 vancer" {
+		t.Errorf("listed users %s", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/permissions/ian2", nil))
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+	if keys := jsonKeys(t, w.Body.Bytes()); keys != "divisionRoles name permissions" {
+		t.Errorf("user has keys %s", keys)
+	}
+	var user User
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(roleNames(user.DivisionRoles)); got != "[marketing:admin_leader:1]" {
+		t.Errorf("ian2 has division roles %s", got)
+	}
+	if got := fmt.Sprint(grantedActions(user.Permissions)); got != "[news:read]" {
+		t.Errorf("ian2 is granted %s", got)
+	}
+	for _, listed := range users {
+		if listed.Name == "ian2" && fmt.Sprint(grantedActions(listed.Permissions)) != "[news:read]" {
+			t.Errorf("ian2 is listed with %s", grantedActions(listed.Permissions))
+		}
+	}
+}
+
+func TestDivisionPermissionHandlers(t *testing.T) {
+	handler := newServer(openTestPolicy(t, permissionRules), openTestRepository(t), nil, nil).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/divisions/permissions", nil))
+	var divisions []Division
+	if err := json.Unmarshal(w.Body.Bytes(), &divisions); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	names := []string{}
+	for _, division := range divisions {
+		names = append(names, string(division.Name))
+	}
+	if got := strings.Join(names, " "); got != "Company marketing Guest" {
+		t.Errorf("listed divisions %s", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/divisions/permissions/marketing", nil))
+	if keys := jsonKeys(t, w.Body.Bytes()); keys != "divisionRoles name type" {
+		t.Errorf("division has keys %s", keys)
+	}
+	var division Division
+	if err := json.Unmarshal(w.Body.Bytes(), &division); err != nil {
+		t.Fatal(err)
+	}
+	if division.Type != DivisionTypeDivision {
+		t.Errorf("marketing has type %s", division.Type)
+	}
+	granted := []string{}
+	for _, divisionRole := range division.DivisionRoles {
+		granted = append(granted, fmt.Sprintf("%s:%d %v", divisionRole.Name, divisionRole.Level, grantedActions(divisionRole.Permissions)))
+	}
+	if got := strings.Join(granted, ", "); got != "admin:0 [], admin_leader:1 [news:read]" {
+		t.Errorf("marketing roles are granted %s", got)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/constant"
+	"github.com/casbin/casbin/v2/persist"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultModelPath  = "model_my.conf"
+	DefaultPolicyPath = "policy_my.csv"
+
+	sqliteSourcePrefix = "sqlite:"
+	mysqlSourcePrefix  = "mysql:"
+)
+
+// openPolicySource returns an enforcer of modelPath loaded from source. A
+// source is a CSV file path, "sqlite:<path>" or "mysql:<dsn>"; both databases
+// keep the rules in their casbin_rule table.
+func openPolicySource(modelPath string, source string) (*Enforcer, error) {
+	adapter, err := policyAdapter(source)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("policyAdapter(%s)", source))
+	}
+
+	enforcer, err := casbin.NewEnforcer(modelPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "casbin.NewEnforcer")
+	}
+	e := &Enforcer{Enforcer: enforcer}
+	e.SetFieldIndex("p", constant.SubjectIndex, 0)
+	e.SetFieldIndex("p", constant.DomainIndex, 1)
+	e.SetFieldIndex("p", constant.ObjectIndex, 2)
+	e.SetAdapter(adapter)
+
+	if err := e.LoadPolicy(); err != nil {
+		return nil, errors.Wrap(err, "LoadPolicy")
+	}
+	if err := preparePolicy(e); err != nil {
+		return nil, errors.Wrap(err, "preparePolicy")
+	}
+	return e, nil
+}
+
+func policyAdapter(source string) (persist.Adapter, error) {
+	gdb, err := policyDB(source)
+	if err != nil {
+		return nil, err
+	}
+	if gdb == nil {
+		return fileadapter.NewAdapter(source), nil
+	}
+	return db.NewAdapter(gdb)
+}
+
+// policyDB opens the database of a "sqlite:" or "mysql:" source. It returns
+// nil for a CSV file.
+func policyDB(source string) (*gorm.DB, error) {
+	switch {
+	case strings.HasPrefix(source, sqliteSourcePrefix):
+		gdb, err := db.OpenSQLite(strings.TrimPrefix(source, sqliteSourcePrefix))
+		if err != nil {
+			return nil, errors.Wrap(err, "db.OpenSQLite")
+		}
+		return gdb, nil
+	case strings.HasPrefix(source, mysqlSourcePrefix):
+		gdb, err := db.OpenMySQL(strings.TrimPrefix(source, mysqlSourcePrefix))
+		if err != nil {
+			return nil, errors.Wrap(err, "db.OpenMySQL")
+		}
+		return gdb, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package v1
+
+import (
+	"context"
+
+	"casbin-playground/db"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Repository reads the divisions and division roles that matrices are built
+// for. NewGormRepository reads the tables the main package writes.
+type Repository interface {
+	ListDivisions(ctx context.Context) ([]Division, error)
+	ListDivisionRoles(ctx context.Context) ([]DivisionRole, error)
+}
+
+type gormRepository struct {
+	db *gorm.DB
+}
+
+func NewGormRepository(gdb *gorm.DB) (Repository, error) {
+	if err := db.Migrate(gdb); err != nil {
+		return nil, errors.Wrap(err, "db.Migrate")
+	}
+	return &gormRepository{db: gdb}, nil
+}
+
+func (r *gormRepository) ListDivisions(ctx context.Context) ([]Division, error) {
+	var records []db.Division
+	err := r.db.WithContext(ctx).
+		Preload("DivisionRoles", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("level, id")
+		}).
+		Order("id").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "Find divisions")
+	}
+
+	divisions := make([]Division, 0, len(records))
+	for _, record := range records {
+		division := Division{
+			Name: record.Name,
+			Type: DivisionType(record.Type),
+		}
+		for _, divisionRole := range record.DivisionRoles {
+			division.DivisionRoles = append(division.DivisionRoles, DivisionRole{
+				Name:  divisionRole.Name,
+				Level: divisionRole.Level,
+			})
+		}
+		divisions = append(divisions, division)
+	}
+	return divisions, nil
+}
+
+func (r *gormRepository) ListDivisionRoles(ctx context.Context) ([]DivisionRole, error) {
+	var records []db.DivisionRole
+	err := r.db.WithContext(ctx).
+		Preload("Division").
+		Joins("JOIN divisions ON divisions.id = division_roles.division_id").
+		Order("divisions.id, division_roles.level, division_roles.id").
+		Find(&records).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "Find division roles")
+	}
+
+	divisionRoles := make([]DivisionRole, 0, len(records))
+	for _, record := range records {
+		divisionRoles = append(divisionRoles, DivisionRole{
+			Division: &Division{
+				Name: record.Division.Name,
+				Type: DivisionType(record.Division.Type),
+			},
+			Name:  record.Name,
+			Level: record.Level,
+		})
+	}
+	return divisionRoles, nil
+}
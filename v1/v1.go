@@ -1,10 +1,14 @@
 package v1
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"casbin-playground/registry"
+
 	"github.com/casbin/casbin/v2"
+	"github.com/pkg/errors"
 )
 
 type User struct {
@@ -44,26 +48,15 @@ type Action struct {
 	Status bool   `json:"status"`
 }
 
-var allObjects = []string{
-	"obj:account",
-	"obj:location",
-	"obj:organiser",
-	"obj:period",
-	"obj:exhibition",
-	"obj:news_tag",
-	"obj:news",
-	"obj:request_form",
-}
+// vocabulary lists the objects and actions that implicit denials are filled
+// in for.
+var vocabulary = registry.Default()
 
-var allActions = []string{
-	"act:read",
-	"act:create",
-	"act:update",
-	"act:delete",
-	"act:create_limited",
-	"act:update_limited",
-	"act:delete_limited",
+// SetRegistry replaces the default objects and actions.
+func SetRegistry(r *registry.Registry) {
+	vocabulary = r
 }
+
 var actionExpansionMapping = map[string][]string{
 	"act:all": []string{
 		"act:read", "act:create", "act:update", "act:delete", "act:create_limited", "act:update_limited", "act:delete_limited",
@@ -81,8 +74,11 @@ var eftMapping = map[string]bool{
 	"deny":  false,
 }
 
-func ListDivisionsPermission(e *casbin.Enforcer) []Division {
-	divisions := mockListDivisionsFromDB()
+func ListDivisionsPermission(ctx context.Context, e *casbin.Enforcer, repo Repository) ([]Division, error) {
+	divisions, err := repo.ListDivisions(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDivisions")
+	}
 
 	domMapping := completeRolesPolicy(e)
 
@@ -109,11 +105,14 @@ func ListDivisionsPermission(e *casbin.Enforcer) []Division {
 		}
 	}
 
-	return divisions
+	return divisions, nil
 }
 
-func ListDivisionsRolesPermission(e *casbin.Enforcer) []DivisionRole {
-	divisionRoles := mockListDivisionRolesFromDB()
+func ListDivisionsRolesPermission(ctx context.Context, e *casbin.Enforcer, repo Repository) ([]DivisionRole, error) {
+	divisionRoles, err := repo.ListDivisionRoles(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDivisionRoles")
+	}
 
 	domMapping := completeRolesPolicy(e)
 
@@ -141,7 +140,7 @@ func ListDivisionsRolesPermission(e *casbin.Enforcer) []DivisionRole {
 		divisionRoles[i].Permissions = permissions
 	}
 
-	return divisionRoles
+	return divisionRoles, nil
 }
 
 func completeRolesPolicy(e *casbin.Enforcer) map[string]map[string]map[string][]string {
@@ -175,16 +174,16 @@ func completeRolesPolicy(e *casbin.Enforcer) map[string]map[string]map[string][]
 	// Handle implicit denials
 	for dom, roleMapping := range DomMapping {
 		for role, objActions := range roleMapping {
-			for _, obj := range allObjects {
+			for _, obj := range vocabulary.Objects() {
 				if _, ok := objActions[obj]; !ok {
 					// Deny all actions for this object
-					objActions[obj] = make([]string, 0, len(allActions)) // Pre-allocate space for performance
-					for _, act := range allActions {
+					objActions[obj] = make([]string, 0, len(vocabulary.Actions())) // Pre-allocate space for performance
+					for _, act := range vocabulary.Actions() {
 						objActions[obj] = append(objActions[obj], fmt.Sprintf("%s, deny", act))
 					}
 				} else {
 					// Ensure all actions are explicitly included, even with "deny" effects
-					for _, act := range allActions {
+					for _, act := range vocabulary.Actions() {
 						found := false
 						for _, existingAct := range objActions[obj] {
 							if strings.HasPrefix(existingAct, fmt.Sprintf("%s,", act)) {
@@ -212,136 +211,3 @@ func expandActions(action string) []string {
 	}
 	return []string{action}
 }
-
-func mockListUsersFromDB() []User {
-	return []User{
-		User{
-			Name: "jason",
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Division: &Division{
-						Name: "company",
-						Type: DivisionTypeCompany,
-					},
-					Name:  "root",
-					Level: 0,
-				},
-			},
-		},
-		User{
-			Name: "sonnie",
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Division: &Division{
-						Name: "company",
-						Type: DivisionTypeCompany,
-					},
-					Name:  "admin",
-					Level: 1,
-				},
-			},
-		},
-		User{
-			Name: "ian",
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Division: &Division{
-						Name: "marketing",
-						Type: DivisionTypeDivision,
-					},
-					Name:  "admin",
-					Level: 0,
-				},
-			},
-		},
-		User{
-			Name: "vancer",
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Division: &Division{
-						Name: "guest",
-						Type: DivisionTypeGuest,
-					},
-					Name:  "guest",
-					Level: 0,
-				},
-			},
-		},
-	}
-}
-
-func mockListDivisionsFromDB() []Division {
-	return []Division{
-		Division{
-			Name: "company",
-			Type: DivisionTypeCompany,
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Name:  "admin",
-					Level: 1,
-				},
-				DivisionRole{
-					Name:  "admin_member",
-					Level: 2,
-				},
-			},
-		},
-		Division{
-			Name: "marketing",
-			Type: DivisionTypeDivision,
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Name:  "admin",
-					Level: 0,
-				},
-			},
-		},
-		Division{
-			Name: "guest",
-			Type: DivisionTypeGuest,
-			DivisionRoles: []DivisionRole{
-				DivisionRole{
-					Name:  "guest",
-					Level: 0,
-				},
-			},
-		},
-	}
-}
-
-func mockListDivisionRolesFromDB() []DivisionRole {
-	return []DivisionRole{
-		DivisionRole{
-			Division: &Division{
-				Name: "company",
-				Type: DivisionTypeCompany,
-			},
-			Name:  "admin",
-			Level: 1,
-		},
-		DivisionRole{
-			Division: &Division{
-				Name: "company",
-				Type: DivisionTypeCompany,
-			},
-			Name:  "admin_member",
-			Level: 2,
-		},
-		DivisionRole{
-			Division: &Division{
-				Name: "marketing",
-				Type: DivisionTypeDivision,
-			},
-			Name:  "admin",
-			Level: 0,
-		},
-		DivisionRole{
-			Division: &Division{
-				Name: "guest",
-				Type: DivisionTypeGuest,
-			},
-			Name:  "guest",
-			Level: 0,
-		},
-	}
-}
@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"casbin-playground/db"
+
+	"github.com/casbin/casbin/v2"
+)
+
+func TestListDivisionsRolesPermission(t *testing.T) {
+	ctx := context.Background()
+	gdb, err := db.OpenSQLite(filepath.Join(t.TempDir(), "playground.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewGormRepository(gdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	division := db.Division{
+		Name:          "marketing",
+		Type:          string(DivisionTypeDivision),
+		DivisionRoles: []db.DivisionRole{{Name: "admin", Level: 0}, {Name: "editor", Level: 1}},
+	}
+	if err := gdb.Create(&division).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := casbin.NewEnforcer("../model_my.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddPolicy("role:admin:0", "dom:marketing", "obj:news", "act:all", "allow"); err != nil {
+		t.Fatal(err)
+	}
+
+	divisionRoles, err := ListDivisionsRolesPermission(ctx, e, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divisionRoles) != 2 {
+		t.Fatalf("got %d division roles, want 2", len(divisionRoles))
+	}
+	for _, divisionRole := range divisionRoles {
+		if divisionRole.Division == nil || divisionRole.Division.Name != "marketing" || divisionRole.Division.Type != DivisionTypeDivision {
+			t.Errorf("%s has division %+v", divisionRole.Name, divisionRole.Division)
+		}
+	}
+	if divisionRoles[0].Name != "admin" || len(divisionRoles[0].Permissions) != len(vocabulary.Objects()) {
+		t.Errorf("admin has %d permissions, want one per object", len(divisionRoles[0].Permissions))
+	}
+	for _, permission := range divisionRoles[0].Permissions {
+		for _, action := range permission.Actions {
+			if want := permission.Name == "news"; action.Status != want {
+				t.Errorf("admin %s %s = %v, want %v", permission.Name, action.Name, action.Status, want)
+			}
+		}
+	}
+	if divisionRoles[1].Name != "editor" || divisionRoles[1].Permissions != nil {
+		t.Errorf("editor has permissions %v, want none", divisionRoles[1].Permissions)
+	}
+
+	divisions, err := ListDivisionsPermission(ctx, e, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divisions) != 1 || len(divisions[0].DivisionRoles) != 2 || divisions[0].DivisionRoles[0].Permissions == nil {
+		t.Errorf("ListDivisionsPermission = %+v", divisions)
+	}
+}